@@ -29,6 +29,7 @@ type Aria2Download struct {
 	Progress      string  `json:"progress"`
 	ProgressNum   float64 `json:"progress_num"`
 	Speed         string  `json:"speed"`
+	Path          string  `json:"path,omitempty"`
 }
 
 type Aria2RPCRequest struct {
@@ -248,7 +249,9 @@ func parseAria2Status(item Aria2StatusResult) Aria2Download {
 	}
 
 	name := item.GID
+	path := ""
 	if len(item.Files) > 0 && item.Files[0].Path != "" {
+		path = item.Files[0].Path
 		name = item.Files[0].Path
 
 		for i := len(name) - 1; i >= 0; i-- {
@@ -285,6 +288,7 @@ func parseAria2Status(item Aria2StatusResult) Aria2Download {
 		Progress:      fmt.Sprintf("%.1f%%", progress),
 		ProgressNum:   progress,
 		Speed:         ByteCountSI(speed) + "/s",
+		Path:          path,
 	}
 }
 
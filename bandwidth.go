@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const bandwidthStateFile = "bandwidth.json"
+
+// SpeedLimit values are in KiB/s, 0 means unlimited.
+type SpeedLimit struct {
+	Download int64 `json:"download"`
+	Upload   int64 `json:"upload"`
+}
+
+// ScheduleRule applies Limit on the given weekdays (0 is Sunday) between
+// Start and End, written as "15:04". A rule whose End is before its Start
+// runs past midnight.
+type ScheduleRule struct {
+	Days  []time.Weekday `json:"days"`
+	Start string         `json:"start"`
+	End   string         `json:"end"`
+	Limit SpeedLimit     `json:"limit"`
+}
+
+type ActiveLimit struct {
+	SpeedLimit
+	Source string `json:"source"`
+}
+
+var (
+	bandwidthState struct {
+		Global   SpeedLimit     `json:"global"`
+		Schedule []ScheduleRule `json:"schedule"`
+	}
+	bandwidthMutex sync.RWMutex
+	appliedLimit   SpeedLimit
+)
+
+func InitBandwidth() {
+	bandwidthMutex.Lock()
+	defer bandwidthMutex.Unlock()
+	if err := loadState(bandwidthStateFile, &bandwidthState); err != nil {
+		log.Printf("Could not load bandwidth limits: %v", err)
+	}
+}
+
+func saveBandwidthState() {
+	if err := saveState(bandwidthStateFile, &bandwidthState); err != nil {
+		log.Printf("Could not save bandwidth limits: %v", err)
+	}
+}
+
+func (l SpeedLimit) Validate() error {
+	if l.Download < 0 || l.Upload < 0 {
+		return fmt.Errorf("limits must not be negative")
+	}
+	return nil
+}
+
+func (l SpeedLimit) String() string {
+	return "down " + formatLimit(l.Download) + ", up " + formatLimit(l.Upload)
+}
+
+func formatLimit(kib int64) string {
+	if kib == 0 {
+		return "unlimited"
+	}
+	return ByteCountSI(kib*1024) + "/s"
+}
+
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (r ScheduleRule) Validate() error {
+	if len(r.Days) == 0 {
+		return fmt.Errorf("schedule rule has no days")
+	}
+	for _, d := range r.Days {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("invalid weekday %d", d)
+		}
+	}
+	if _, err := parseClock(r.Start); err != nil {
+		return err
+	}
+	if _, err := parseClock(r.End); err != nil {
+		return err
+	}
+	return r.Limit.Validate()
+}
+
+func (r ScheduleRule) hasDay(d time.Weekday) bool {
+	for _, day := range r.Days {
+		if day == d {
+			return true
+		}
+	}
+	return false
+}
+
+func (r ScheduleRule) Matches(now time.Time) bool {
+	start, err := parseClock(r.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(r.End)
+	if err != nil {
+		return false
+	}
+	minute := now.Hour()*60 + now.Minute()
+	if start <= end {
+		return r.hasDay(now.Weekday()) && minute >= start && minute < end
+	}
+	yesterday := (now.Weekday() + 6) % 7
+	return (r.hasDay(now.Weekday()) && minute >= start) || (r.hasDay(yesterday) && minute < end)
+}
+
+// ActiveSpeedLimit returns the limit of the first schedule rule matching
+// now, falling back to the global limit.
+func ActiveSpeedLimit(now time.Time) ActiveLimit {
+	bandwidthMutex.RLock()
+	defer bandwidthMutex.RUnlock()
+	for i, r := range bandwidthState.Schedule {
+		if r.Matches(now) {
+			return ActiveLimit{SpeedLimit: r.Limit, Source: fmt.Sprintf("schedule #%d", i+1)}
+		}
+	}
+	return ActiveLimit{SpeedLimit: bandwidthState.Global, Source: "global"}
+}
+
+func GetBandwidthLimits() interface{} {
+	bandwidthMutex.RLock()
+	defer bandwidthMutex.RUnlock()
+	return map[string]interface{}{
+		"global":   bandwidthState.Global,
+		"schedule": bandwidthState.Schedule,
+	}
+}
+
+func SetGlobalSpeedLimit(l SpeedLimit) error {
+	if err := l.Validate(); err != nil {
+		return err
+	}
+	bandwidthMutex.Lock()
+	bandwidthState.Global = l
+	saveBandwidthState()
+	bandwidthMutex.Unlock()
+	return applySpeedLimits()
+}
+
+func SetSpeedSchedule(rules []ScheduleRule) error {
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return err
+		}
+	}
+	bandwidthMutex.Lock()
+	bandwidthState.Schedule = rules
+	saveBandwidthState()
+	bandwidthMutex.Unlock()
+	return applySpeedLimits()
+}
+
+// applySpeedLimits restarts the session when the active limit differs
+// from the one it was created with, rain reads limits only at startup.
+// Limits apply to the whole session, rain has no per-torrent limits.
+func applySpeedLimits() error {
+	restartMutex.Lock()
+	defer restartMutex.Unlock()
+	active := ActiveSpeedLimit(time.Now())
+	sessionMutex.RLock()
+	applied := appliedLimit
+	sessionMutex.RUnlock()
+	if active.SpeedLimit == applied {
+		return nil
+	}
+	config := newClientConfig()
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	log.Printf("Applying %s speed limit: %s", active.Source, active.SpeedLimit)
+	return restartClient(config)
+}
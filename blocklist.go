@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	blocklistStateFile = "blocklist.json"
+	// Rain only loads blocklists over HTTP and only in P2P format, so the
+	// parsed list is served to it from a loopback listener, which it polls
+	// at this interval.
+	blocklistPoll = 10 * time.Minute
+)
+
+// BlocklistConfig says where the IP blocklist comes from: a local file or
+// an http(s) URL in P2P, eMule or DAT format, optionally gzipped.
+type BlocklistConfig struct {
+	Source string  `json:"source"`
+	Hours  float64 `json:"hours"`
+}
+
+type BlocklistStatus struct {
+	BlocklistConfig
+	Ranges  int    `json:"ranges"`
+	Updated string `json:"updated,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Applied is false until rain has fetched the current list, which it
+	// does within ApplyWithin seconds of it being loaded. Rain does not
+	// count the connections it blocks, so BlockedCounted is always false.
+	Applied        bool `json:"applied"`
+	ApplyWithin    int  `json:"apply_within"`
+	BlockedCounted bool `json:"blocked_counted"`
+}
+
+type ipRange struct {
+	first, last uint32
+}
+
+var (
+	blocklistConfig = BlocklistConfig{Hours: 24}
+	blocklistMutex  sync.RWMutex
+	blocklistLoad   sync.Mutex
+	blocklistRanges int
+	blocklistData   []byte // gzipped P2P list served to rain
+	blocklistTime   time.Time
+	blocklistError  string
+	blocklistServed time.Time
+	blocklistURL    string
+	blocklistClient = &http.Client{Timeout: 2 * time.Minute}
+	blocklistReload = make(chan struct{}, 1)
+)
+
+func blocklistCacheFile() string {
+	return filepath.Join(StateDir, "blocklist.p2p.gz")
+}
+
+// InitBlocklist loads the cached list and starts serving it to rain. It
+// runs before the session is created so rain can fetch it on startup.
+func InitBlocklist() {
+	blocklistMutex.Lock()
+	if err := loadState(blocklistStateFile, &blocklistConfig); err != nil {
+		log.Printf("Could not load blocklist settings: %v", err)
+	}
+	if data, err := ioutil.ReadFile(blocklistCacheFile()); err == nil && blocklistConfig.Source != "" {
+		if ranges, err := parseBlocklist(bytes.NewReader(data)); err == nil {
+			blocklistRanges = len(ranges)
+			blocklistData = data
+			if fi, err := os.Stat(blocklistCacheFile()); err == nil {
+				blocklistTime = fi.ModTime()
+			}
+		}
+	}
+	blocklistMutex.Unlock()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Printf("Could not start blocklist listener: %v", err)
+		return
+	}
+	blocklistURL = "http://" + ln.Addr().String() + "/blocklist.p2p.gz"
+	go http.Serve(ln, http.HandlerFunc(serveBlocklist))
+	go refreshBlocklist()
+}
+
+func serveBlocklist(w http.ResponseWriter, r *http.Request) {
+	blocklistMutex.Lock()
+	data := blocklistData
+	blocklistServed = time.Now()
+	blocklistMutex.Unlock()
+	if data == nil {
+		// An empty list clears the rules rain loaded before.
+		data = gzipBytes(nil)
+	}
+	w.Write(data)
+}
+
+func refreshBlocklist() {
+	failed := false
+	for {
+		blocklistMutex.RLock()
+		interval := time.Duration(blocklistConfig.Hours * float64(time.Hour))
+		last := blocklistTime
+		blocklistMutex.RUnlock()
+		if interval <= 0 {
+			interval = 24 * time.Hour
+		}
+		wait := time.Until(last.Add(interval))
+		if failed {
+			// Retry failed fetches sooner than the next refresh.
+			wait = time.Hour
+		}
+		select {
+		case <-time.After(wait):
+		case <-blocklistReload:
+		}
+		err := ReloadBlocklist()
+		if err != nil {
+			log.Printf("Could not load blocklist: %v", err)
+		}
+		failed = err != nil
+	}
+}
+
+// ReloadBlocklist fetches and parses the list from its source. The
+// previous list stays active when that fails.
+func ReloadBlocklist() error {
+	blocklistLoad.Lock()
+	defer blocklistLoad.Unlock()
+	blocklistMutex.RLock()
+	src := blocklistConfig.Source
+	blocklistMutex.RUnlock()
+
+	var data []byte
+	var n int
+	var err error
+	if src != "" {
+		var ranges []ipRange
+		if ranges, err = loadBlocklist(src); err == nil {
+			if len(ranges) == 0 {
+				err = fmt.Errorf("no IP ranges found in %s", src)
+			} else {
+				n = len(ranges)
+				data = gzipBytes(formatBlocklist(ranges))
+			}
+		}
+	}
+
+	blocklistMutex.Lock()
+	defer blocklistMutex.Unlock()
+	if err != nil {
+		blocklistError = err.Error()
+		return err
+	}
+	blocklistError = ""
+	blocklistTime = time.Now()
+	blocklistRanges = n
+	blocklistData = data
+	if data == nil {
+		os.Remove(blocklistCacheFile())
+	} else if err := ioutil.WriteFile(blocklistCacheFile(), data, 0644); err != nil {
+		log.Printf("Could not cache blocklist: %v", err)
+	}
+	return nil
+}
+
+func loadBlocklist(src string) ([]ipRange, error) {
+	var r io.Reader
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		resp, err := blocklistClient.Get(src)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("%s", resp.Status)
+		}
+		r = resp.Body
+	} else {
+		f, err := os.Open(src)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	return parseBlocklist(io.LimitReader(r, 256<<20))
+}
+
+// parseBlocklist reads P2P ("name:1.2.3.0-1.2.3.255") and eMule DAT
+// ("1.2.3.0 - 1.2.3.255 , 100 , name") lines, gzipped or not, and
+// returns the blocked IPv4 ranges sorted and merged. DAT entries with an
+// access level of 128 or more are allowed, as in eMule.
+func parseBlocklist(r io.Reader) ([]ipRange, error) {
+	br := bufio.NewReader(r)
+	if magic, _ := br.Peek(2); len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		br = bufio.NewReader(gz)
+	}
+	var ranges []ipRange
+	scanner := bufio.NewScanner(br)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "//") {
+			continue
+		}
+		if fields := strings.SplitN(line, ",", 3); len(fields) >= 2 {
+			if r, ok := parseIPRange(fields[0]); ok {
+				if level, err := strconv.Atoi(strings.TrimSpace(fields[1])); err == nil && level < 128 {
+					ranges = append(ranges, r)
+				}
+				continue
+			}
+		}
+		// P2P names may contain colons, the range follows the last one.
+		if r, ok := parseIPRange(line[strings.LastIndex(line, ":")+1:]); ok {
+			ranges = append(ranges, r)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mergeRanges(ranges), nil
+}
+
+func parseIPRange(s string) (ipRange, bool) {
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) != 2 {
+		return ipRange{}, false
+	}
+	first, ok1 := parseIPv4(parts[0])
+	last, ok2 := parseIPv4(parts[1])
+	if !ok1 || !ok2 || last < first {
+		return ipRange{}, false
+	}
+	return ipRange{first, last}, true
+}
+
+// parseIPv4 accepts the zero padded addresses of DAT files, which
+// net.ParseIP rejects.
+func parseIPv4(s string) (uint32, bool) {
+	parts := strings.Split(strings.TrimSpace(s), ".")
+	if len(parts) != 4 {
+		return 0, false
+	}
+	var ip uint32
+	for _, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || n > 255 {
+			return 0, false
+		}
+		ip = ip<<8 | uint32(n)
+	}
+	return ip, true
+}
+
+func mergeRanges(ranges []ipRange) []ipRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].first < ranges[j].first })
+	var merged []ipRange
+	for _, r := range ranges {
+		if n := len(merged); n > 0 && (r.first <= merged[n-1].last || r.first == merged[n-1].last+1) {
+			if r.last > merged[n-1].last {
+				merged[n-1].last = r.last
+			}
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+func formatBlocklist(ranges []ipRange) []byte {
+	var buf bytes.Buffer
+	for _, r := range ranges {
+		fmt.Fprintf(&buf, "cloudtorrent:%s-%s\n", formatIPv4(r.first), formatIPv4(r.last))
+	}
+	return buf.Bytes()
+}
+
+func formatIPv4(ip uint32) string {
+	return net.IPv4(byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip)).String()
+}
+
+func gzipBytes(data []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	gz.Write(data)
+	gz.Close()
+	return buf.Bytes()
+}
+
+func GetBlocklistStatus() BlocklistStatus {
+	blocklistMutex.RLock()
+	defer blocklistMutex.RUnlock()
+	s := BlocklistStatus{
+		BlocklistConfig: blocklistConfig,
+		Ranges:          blocklistRanges,
+		Error:           blocklistError,
+		Applied:         blocklistURL != "" && !blocklistServed.Before(blocklistTime),
+		ApplyWithin:     int(blocklistPoll.Seconds()),
+	}
+	if !blocklistTime.IsZero() {
+		s.Updated = blocklistTime.Format(time.RFC3339)
+	}
+	return s
+}
+
+func SetBlocklistConfig(cfg BlocklistConfig) error {
+	cfg.Source = strings.TrimSpace(cfg.Source)
+	if cfg.Source != "" {
+		u, err := url.Parse(cfg.Source)
+		isURL := err == nil && (u.Scheme == "http" || u.Scheme == "https")
+		if !isURL && !filepath.IsAbs(cfg.Source) {
+			return fmt.Errorf("blocklist source must be an http(s) URL or an absolute path")
+		}
+	}
+	if cfg.Hours < 0 {
+		return fmt.Errorf("refresh interval must not be negative")
+	}
+	blocklistMutex.Lock()
+	changed := cfg.Source != blocklistConfig.Source
+	blocklistConfig = cfg
+	if err := saveState(blocklistStateFile, &blocklistConfig); err != nil {
+		log.Printf("Could not save blocklist settings: %v", err)
+	}
+	blocklistMutex.Unlock()
+	if changed {
+		return ReloadBlocklist()
+	}
+	select {
+	case blocklistReload <- struct{}{}:
+	default:
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestParseBlocklistP2P(t *testing.T) {
+	list := "# comment\n" +
+		"Some: Org:1.2.3.0-1.2.3.255\n" +
+		"other:10.0.0.1-10.0.0.1\n" +
+		"bad:1.2.3.4-1.2.3.0\n" +
+		"garbage line\n"
+	ranges, err := parseBlocklist(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1.2.3.0-1.2.3.255", "10.0.0.1-10.0.0.1"}
+	checkRanges(t, ranges, want)
+}
+
+func TestParseBlocklistDAT(t *testing.T) {
+	list := "// comment\n" +
+		"001.002.003.000 - 001.002.003.255 , 000 , blocked\n" +
+		"005.005.005.005 - 005.005.005.010 , 200 , allowed\n" +
+		"010.000.000.000 - 010.000.000.255 , 127 , blocked, with comma\n"
+	ranges, err := parseBlocklist(strings.NewReader(list))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRanges(t, ranges, []string{"1.2.3.0-1.2.3.255", "10.0.0.0-10.0.0.255"})
+}
+
+func TestParseBlocklistGzipMerges(t *testing.T) {
+	list := "a:1.0.0.0-1.0.0.10\nb:1.0.0.5-1.0.0.20\nc:1.0.0.21-1.0.0.30\nd:2.0.0.0-2.0.0.1\n"
+	ranges, err := parseBlocklist(bytes.NewReader(gzipBytes([]byte(list))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRanges(t, ranges, []string{"1.0.0.0-1.0.0.30", "2.0.0.0-2.0.0.1"})
+
+	// The list served to rain parses back to the same ranges.
+	again, err := parseBlocklist(bytes.NewReader(gzipBytes(formatBlocklist(ranges))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRanges(t, again, []string{"1.0.0.0-1.0.0.30", "2.0.0.0-2.0.0.1"})
+}
+
+func checkRanges(t *testing.T, ranges []ipRange, want []string) {
+	t.Helper()
+	var got []string
+	for _, r := range ranges {
+		got = append(got, formatIPv4(r.first)+"-"+formatIPv4(r.last))
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("ranges %v, want %v", got, want)
+	}
+}
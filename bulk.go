@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+// Bulk actions
+const (
+	BulkPause       = "pause"
+	BulkResume      = "resume"
+	BulkRemove      = "remove"
+	BulkRemoveData  = "remove_data"
+	BulkRecheck     = "recheck"
+	BulkSetCategory = "set_category"
+)
+
+// BulkRequest applies Action to the torrents listed in UIDs, or to every
+// torrent matching Filter. Category is the argument of set_category.
+type BulkRequest struct {
+	Action   string      `json:"action"`
+	UIDs     []string    `json:"uids,omitempty"`
+	Filter   *BulkFilter `json:"filter,omitempty"`
+	Category string      `json:"category,omitempty"`
+}
+
+// BulkFilter selects torrents by status as shown in the torrent list,
+// category, one of their comma separated labels and a name regexp. Empty
+// fields match everything.
+type BulkFilter struct {
+	Status   string `json:"status,omitempty"`
+	Category string `json:"category,omitempty"`
+	Label    string `json:"label,omitempty"`
+	Name     string `json:"name,omitempty"`
+}
+
+type BulkResult struct {
+	UID    string `json:"uid"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// Data and Path tell what remove and remove_data did with the files.
+	Data string `json:"data,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+func (r BulkRequest) validate() error {
+	switch r.Action {
+	case BulkPause, BulkResume, BulkRemove, BulkRemoveData, BulkRecheck:
+	case BulkSetCategory:
+		if _, ok := GetCategory(r.Category); r.Category != "" && !ok {
+			return fmt.Errorf("category not found")
+		}
+	default:
+		return fmt.Errorf("invalid action %q", r.Action)
+	}
+	if len(r.UIDs) == 0 && (r.Filter == nil || *r.Filter == BulkFilter{}) {
+		return fmt.Errorf("no uids or filter provided")
+	}
+	return nil
+}
+
+// selectTorrents returns the uids a request applies to. Listed uids that
+// are not in the session are kept so they are reported.
+func (r BulkRequest) selectTorrents() ([]string, error) {
+	if len(r.UIDs) > 0 {
+		return r.UIDs, nil
+	}
+	f := r.Filter
+	var name *regexp.Regexp
+	if f.Name != "" {
+		var err error
+		if name, err = regexp.Compile("(?i)" + f.Name); err != nil {
+			return nil, fmt.Errorf("invalid name pattern: %v", err)
+		}
+	}
+	ids := []string{}
+	for _, t := range GetTorrents() {
+		if f.matches(t, name) {
+			ids = append(ids, t.ID())
+		}
+	}
+	return ids, nil
+}
+
+func (f *BulkFilter) matches(t *torrent.Torrent, name *regexp.Regexp) bool {
+	if f.Status != "" {
+		if status, _ := GetStats(t); !strings.EqualFold(status, f.Status) {
+			return false
+		}
+	}
+	meta := GetMeta(t.ID())
+	if f.Category != "" && meta.Category != f.Category {
+		return false
+	}
+	if f.Label != "" {
+		found := false
+		for _, l := range SplitList(meta.Label) {
+			if strings.EqualFold(l, f.Label) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return name == nil || name.MatchString(t.Name())
+}
+
+// ApplyBulk runs a bulk action and returns the outcome for every selected
+// torrent.
+func ApplyBulk(r BulkRequest) ([]BulkResult, error) {
+	if err := r.validate(); err != nil {
+		return nil, err
+	}
+	ids, err := r.selectTorrents()
+	if err != nil {
+		return nil, err
+	}
+	results := []BulkResult{}
+	for _, id := range ids {
+		res := BulkResult{UID: id, Status: "ok"}
+		t := GetSession().GetTorrent(id)
+		if t == nil {
+			err = ErrTorrentNotFound
+		} else {
+			res.Name = t.Name()
+			err = r.apply(id, &res)
+		}
+		if err != nil {
+			res.Status = "error"
+			res.Error = err.Error()
+		} else if r.Action == BulkRemove || r.Action == BulkRemoveData {
+			BroadcastMessage("torrent_removed", map[string]string{"uid": id})
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (r BulkRequest) apply(id string, res *BulkResult) error {
+	var err error
+	switch r.Action {
+	case BulkPause:
+		_, err = PauseTorrentByID(id)
+	case BulkResume:
+		_, err = ResumeTorrentByID(id)
+	case BulkRemove, BulkRemoveData:
+		var removed RemovedTorrent
+		removed, err = RemoveTorrent(id, r.Action == BulkRemoveData)
+		res.Data, res.Path = removed.Data, removed.Path
+	case BulkRecheck:
+		err = RecheckTorrent(id)
+	case BulkSetCategory:
+		err = SetTorrentCategory(id, r.Category)
+	}
+	return err
+}
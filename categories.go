@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+const categoriesStateFile = "categories.json"
+
+// Category groups torrents under their own directory below Root, with an
+// optional seeding policy.
+// Completed torrents are moved or hardlinked to Library when it is set.
+type Category struct {
+	Name        string      `json:"name"`
+	Dir         string      `json:"dir"`
+	Seed        *SeedPolicy `json:"seed,omitempty"`
+	Library     string      `json:"library,omitempty"`
+	LibraryMode string      `json:"library_mode,omitempty"`
+}
+
+var (
+	categories      = make(map[string]Category)
+	categoriesMutex sync.RWMutex
+	// Torrents whose data is being moved, which the queue must not start.
+	dataBusy      = make(map[string]bool)
+	dataBusyMutex sync.Mutex
+)
+
+func InitCategories() {
+	categoriesMutex.Lock()
+	defer categoriesMutex.Unlock()
+	if err := loadState(categoriesStateFile, &categories); err != nil {
+		log.Printf("Could not load categories: %v", err)
+	}
+	if categories == nil {
+		categories = make(map[string]Category)
+	}
+}
+
+func saveCategories() {
+	if err := saveState(categoriesStateFile, &categories); err != nil {
+		log.Printf("Could not save categories: %v", err)
+	}
+}
+
+func GetCategories() []Category {
+	categoriesMutex.RLock()
+	defer categoriesMutex.RUnlock()
+	list := []Category{}
+	for _, c := range categories {
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func GetCategory(name string) (Category, bool) {
+	categoriesMutex.RLock()
+	defer categoriesMutex.RUnlock()
+	c, ok := categories[name]
+	return c, ok
+}
+
+// categoryDir resolves a category directory, which must stay inside Root.
+func categoryDir(dir string) (string, error) {
+	abs := filepath.Join(Root, filepath.Clean("/"+dir))
+	if abs == Root || WithinDir(filepath.Join(Root, "torrents"), abs) || WithinDir(StateDir, abs) {
+		return "", fmt.Errorf("invalid category directory %q", dir)
+	}
+	return abs, nil
+}
+
+func SetCategory(c Category) error {
+	c.Name = strings.TrimSpace(c.Name)
+	if c.Name == "" {
+		return fmt.Errorf("category name is required")
+	}
+	if c.Dir == "" {
+		c.Dir = c.Name
+	}
+	if _, err := categoryDir(c.Dir); err != nil {
+		return err
+	}
+	if c.Seed != nil {
+		if err := c.Seed.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Library != "" && c.LibraryMode == "" {
+		c.LibraryMode = LibraryMove
+	}
+	if err := validLibrary(c.Library, c.LibraryMode); err != nil {
+		return err
+	}
+	categoriesMutex.Lock()
+	defer categoriesMutex.Unlock()
+	if old, ok := categories[c.Name]; ok && old.Dir != c.Dir {
+		return fmt.Errorf("the directory of an existing category can not be changed")
+	}
+	categories[c.Name] = c
+	saveCategories()
+	return nil
+}
+
+// RemoveCategory deletes a category. Its torrents keep their data where it
+// is and no longer belong to any category.
+func RemoveCategory(name string) error {
+	categoriesMutex.Lock()
+	if _, ok := categories[name]; !ok {
+		categoriesMutex.Unlock()
+		return fmt.Errorf("category not found")
+	}
+	delete(categories, name)
+	saveCategories()
+	categoriesMutex.Unlock()
+
+	for _, t := range GetTorrents() {
+		if GetMeta(t.ID()).Category == name {
+			setMetaCategory(t.ID(), "")
+		}
+	}
+	return nil
+}
+
+// CategoryForDir finds the category whose directory, or name, is the save
+// directory requested by a client written for another torrent client.
+func CategoryForDir(dir string) string {
+	if dir == "" {
+		return ""
+	}
+	dir = filepath.Clean(dir)
+	for _, c := range GetCategories() {
+		if abs, err := categoryDir(c.Dir); (err == nil && abs == dir) || filepath.Base(dir) == c.Name {
+			return c.Name
+		}
+	}
+	return ""
+}
+
+// SetTorrentCategory assigns a torrent to a category, moving its data into
+// the category directory. An empty name moves it back to the default one.
+func SetTorrentCategory(id, name string) error {
+	t := GetSession().GetTorrent(id)
+	if t == nil {
+		return ErrTorrentNotFound
+	}
+	dir := ""
+	if name != "" {
+		c, ok := GetCategory(name)
+		if !ok {
+			return fmt.Errorf("category not found")
+		}
+		if dir, _ = categoryDir(c.Dir); dir == "" {
+			return fmt.Errorf("invalid category directory %q", c.Dir)
+		}
+	}
+	if err := placeTorrentData(t, dir); err != nil {
+		return err
+	}
+	setMetaCategory(id, name)
+	return nil
+}
+
+// TorrentDataDir returns where the data of a torrent actually lives. Rain
+// always uses Root/torrents/<id>, which is a symlink once the data has been
+// placed somewhere else.
+func TorrentDataDir(id string) string {
+	link := filepath.Join(Root, "torrents", id)
+	if target, err := os.Readlink(link); err == nil {
+		return target
+	}
+	return link
+}
+
+// placeTorrentData moves the data of a torrent into dir and points rain's
+// data directory at it. An empty dir moves the data back into rain's own
+// data directory.
+func placeTorrentData(t *torrent.Torrent, dir string) error {
+	link := filepath.Join(Root, "torrents", t.ID())
+	current := TorrentDataDir(t.ID())
+	target := link
+	if dir != "" {
+		target = filepath.Join(dir, t.ID())
+	}
+	if current == target {
+		return nil
+	}
+	if seededInPlace(t.ID()) {
+		return fmt.Errorf("torrent data is not in a directory of its own and can not be moved")
+	}
+	if !markDataBusy(t.ID()) {
+		return fmt.Errorf("torrent data is already being moved")
+	}
+	defer unmarkDataBusy(t.ID())
+	running, err := stopAndWait(t)
+	if err != nil {
+		return err
+	}
+	if running {
+		defer resumeAfterMove(t.ID())
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	if target == link {
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	}
+	if _, err := os.Stat(current); err == nil {
+		err = moveDir(current, target)
+	} else {
+		err = os.MkdirAll(target, 0755)
+	}
+	if err != nil {
+		if target == link {
+			os.Symlink(current, link)
+		}
+		return err
+	}
+	if target == link {
+		return nil
+	}
+	if current != link {
+		if err := os.Remove(link); err != nil {
+			return err
+		}
+	}
+	return os.Symlink(target, link)
+}
+
+// markDataBusy claims a torrent for a data move, it fails when another
+// move is already in progress.
+func markDataBusy(id string) bool {
+	dataBusyMutex.Lock()
+	defer dataBusyMutex.Unlock()
+	if dataBusy[id] {
+		return false
+	}
+	dataBusy[id] = true
+	return true
+}
+
+func unmarkDataBusy(id string) {
+	dataBusyMutex.Lock()
+	defer dataBusyMutex.Unlock()
+	delete(dataBusy, id)
+}
+
+func isDataBusy(id string) bool {
+	dataBusyMutex.Lock()
+	defer dataBusyMutex.Unlock()
+	return dataBusy[id]
+}
+
+// stopAndWait stops a torrent and waits until rain has closed its files.
+func stopAndWait(t *torrent.Torrent) (bool, error) {
+	if t.Stats().Status == torrent.Stopped {
+		return false, nil
+	}
+	if err := t.Stop(); err != nil {
+		return false, err
+	}
+	for i := 0; i < 100 && t.Stats().Status != torrent.Stopped; i++ {
+		time.Sleep(100 * time.Millisecond)
+	}
+	if t.Stats().Status != torrent.Stopped {
+		return true, fmt.Errorf("timed out stopping torrent")
+	}
+	return true, nil
+}
+
+// resumeAfterMove starts a torrent that was running before its data was
+// moved, unless it was paused meanwhile. It is looked up again as the
+// session may have been restarted during the move.
+func resumeAfterMove(id string) {
+	if t := GetSession().GetTorrent(id); t != nil && !isHeld(id) {
+		t.Start()
+	}
+}
+
+// moveDir renames src to dst, copying when they are on different devices.
+func moveDir(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	tmp := dst + ".partial"
+	os.RemoveAll(tmp)
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		out := filepath.Join(tmp, rel)
+		if info.IsDir() {
+			return os.MkdirAll(out, info.Mode().Perm())
+		}
+		return copyFile(path, out, info.Mode().Perm())
+	})
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	return os.RemoveAll(src)
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestCategoryForDir(t *testing.T) {
+	categoriesMutex.Lock()
+	saved := categories
+	categories = map[string]Category{
+		"movies": {Name: "movies", Dir: "media/films"},
+		"tv":     {Name: "tv", Dir: "tv"},
+	}
+	categoriesMutex.Unlock()
+	defer func() {
+		categoriesMutex.Lock()
+		categories = saved
+		categoriesMutex.Unlock()
+	}()
+
+	for dir, want := range map[string]string{
+		"":                                     "",
+		filepath.Join(Root, "media", "films"):  "movies",
+		filepath.Join(Root, "media/films/"):    "movies",
+		"/elsewhere/movies":                    "movies",
+		filepath.Join(Root, "tv"):              "tv",
+		filepath.Join(Root, "media"):           "",
+		filepath.Join(Root, "media", "filmsX"): "",
+	} {
+		if got := CategoryForDir(dir); got != want {
+			t.Errorf("CategoryForDir(%q) = %q, want %q", dir, got, want)
+		}
+	}
+}
+
+func TestCategoryDirRejectsReservedPaths(t *testing.T) {
+	for _, dir := range []string{"", "/", "torrents", "torrents/abc", ".cloudtorrent", "../.."} {
+		if _, err := categoryDir(dir); err == nil {
+			t.Errorf("categoryDir(%q) accepted", dir)
+		}
+	}
+	if abs, err := categoryDir("torrentsX"); err != nil || abs != filepath.Join(Root, "torrentsX") {
+		t.Errorf("categoryDir(torrentsX) = %q, %v", abs, err)
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+	"github.com/zeebo/bencode"
+)
+
+const (
+	minPieceLength = 16 << 10
+	maxPieceLength = 16 << 20
+	// autoPieces is the piece count an automatic piece size aims for.
+	autoPieces = 1500
+)
+
+// CreateOptions describe a torrent made from a file or directory below
+// Root. A PieceLength of 0 picks one from the total size.
+type CreateOptions struct {
+	Path        string
+	PieceLength int64
+	Trackers    []string
+	WebSeeds    []string
+	Private     bool
+	Comment     string
+	Seed        bool
+}
+
+type CreatedTorrent struct {
+	Name     string `json:"name"`
+	InfoHash string `json:"info_hash"`
+	Magnet   string `json:"magnet"`
+	Torrent  []byte `json:"torrent"`
+	UID      string `json:"uid,omitempty"`
+}
+
+// resolveRootPath turns a path as shown by the file browser, with or
+// without its /downloads prefix, into one inside Root. Data of other
+// torrents may be shared, but not their data directories themselves.
+func resolveRootPath(p string) (string, error) {
+	p = filepath.Clean("/" + filepath.ToSlash(p))
+	if p == "/downloads" || strings.HasPrefix(p, "/downloads/") {
+		p = strings.TrimPrefix(p, "/downloads")
+	}
+	abs := filepath.Join(Root, p)
+	torrents := filepath.Join(Root, "torrents")
+	if abs == Root || abs == filepath.Join(Root, "torrents.db") || WithinDir(StateDir, abs) || abs == torrents || filepath.Dir(abs) == torrents {
+		return "", fmt.Errorf("invalid path %q", p)
+	}
+	return abs, nil
+}
+
+func autoPieceLength(size int64) int64 {
+	length := int64(minPieceLength)
+	for length < maxPieceLength && size/length > autoPieces {
+		length *= 2
+	}
+	return length
+}
+
+// CreateJob is a torrent being created. Hashing runs in the background
+// and its progress is broadcast as "create" messages.
+type CreateJob struct {
+	ID        string          `json:"id"`
+	Path      string          `json:"path"`
+	Status    string          `json:"status"`
+	Progress  float64         `json:"progress"`
+	Error     string          `json:"error,omitempty"`
+	Result    *CreatedTorrent `json:"result,omitempty"`
+	StartTime time.Time       `json:"start_time"`
+}
+
+// createJobTTL is how long finished jobs are kept for clients to collect.
+const createJobTTL = time.Hour
+
+var (
+	createMutex sync.Mutex
+	createJobs  = make(map[string]*CreateJob)
+)
+
+// createPlan is a validated CreateOptions.
+type createPlan struct {
+	opts        CreateOptions
+	abs         string
+	isDir       bool
+	files       []MetaFile
+	total       int64
+	pieceLength int64
+}
+
+func planCreate(opts CreateOptions) (*createPlan, error) {
+	abs, err := resolveRootPath(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	plan := &createPlan{opts: opts, abs: abs, isDir: fi.IsDir()}
+	if fi.IsDir() {
+		err = filepath.Walk(abs, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if info.Mode().IsRegular() {
+				rel, _ := filepath.Rel(abs, p)
+				plan.files = append(plan.files, MetaFile{Path: filepath.ToSlash(rel), Length: info.Size()})
+				plan.total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	} else if fi.Mode().IsRegular() {
+		plan.files = []MetaFile{{Path: fi.Name(), Length: fi.Size()}}
+		plan.total = fi.Size()
+	}
+	if plan.total == 0 {
+		return nil, fmt.Errorf("nothing to share at %q", opts.Path)
+	}
+	plan.pieceLength = opts.PieceLength
+	if plan.pieceLength == 0 {
+		plan.pieceLength = autoPieceLength(plan.total)
+	} else if plan.pieceLength < minPieceLength || plan.pieceLength > maxPieceLength || plan.pieceLength&(plan.pieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece size must be a power of two between %s and %s", ByteCountSI(minPieceLength), ByteCountSI(maxPieceLength))
+	}
+	for _, list := range [][]string{opts.Trackers, opts.WebSeeds} {
+		for _, s := range list {
+			if u, err := url.Parse(s); err != nil || u.Host == "" {
+				return nil, fmt.Errorf("invalid url %q", s)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// StartCreateTorrent validates opts and starts hashing the files at
+// opts.Path into a new torrent. With opts.Seed it is then added to the
+// session and seeded from where the files already are.
+func StartCreateTorrent(opts CreateOptions) (CreateJob, error) {
+	plan, err := planCreate(opts)
+	if err != nil {
+		return CreateJob{}, err
+	}
+	job := &CreateJob{
+		ID:        fmt.Sprintf("create_%d", time.Now().UnixNano()),
+		Path:      ServerPath(plan.abs),
+		Status:    "hashing",
+		StartTime: time.Now(),
+	}
+	createMutex.Lock()
+	for id, j := range createJobs {
+		if j.Status != "hashing" && time.Since(j.StartTime) > createJobTTL {
+			delete(createJobs, id)
+		}
+	}
+	createJobs[job.ID] = job
+	snapshot := *job
+	createMutex.Unlock()
+
+	go runCreate(job, plan)
+	return snapshot, nil
+}
+
+// GetCreateJob returns a copy of a create job.
+func GetCreateJob(id string) (CreateJob, bool) {
+	createMutex.Lock()
+	defer createMutex.Unlock()
+	job, ok := createJobs[id]
+	if !ok {
+		return CreateJob{}, false
+	}
+	return *job, true
+}
+
+func runCreate(job *CreateJob, plan *createPlan) {
+	update := func(fn func()) {
+		createMutex.Lock()
+		fn()
+		snapshot := *job
+		createMutex.Unlock()
+		BroadcastMessage("create", snapshot)
+	}
+	last := -1
+	created, err := buildTorrent(plan, func(done int64) {
+		if pct := int(done * 100 / plan.total); pct != last {
+			last = pct
+			update(func() { job.Progress = float64(pct) })
+		}
+	})
+	update(func() {
+		if err != nil {
+			job.Status = "error"
+			job.Error = err.Error()
+			return
+		}
+		job.Status = "completed"
+		job.Progress = 100
+		job.Result = created
+	})
+	if err == nil && created.UID != "" {
+		BroadcastMessage("torrent_added", map[string]string{"status": "ok"})
+	}
+}
+
+func buildTorrent(plan *createPlan, progress func(done int64)) (*CreatedTorrent, error) {
+	opts, abs := plan.opts, plan.abs
+	pieces, err := hashPieces(abs, plan.isDir, plan.files, plan.pieceLength, progress)
+	if err != nil {
+		return nil, err
+	}
+	info := map[string]interface{}{
+		"name":         filepath.Base(abs),
+		"piece length": plan.pieceLength,
+		"pieces":       pieces,
+	}
+	if plan.isDir {
+		var list []interface{}
+		for _, f := range plan.files {
+			var parts []interface{}
+			for _, part := range strings.Split(f.Path, "/") {
+				parts = append(parts, part)
+			}
+			list = append(list, map[string]interface{}{"length": f.Length, "path": parts})
+		}
+		info["files"] = list
+	} else {
+		info["length"] = plan.total
+	}
+	if opts.Private {
+		info["private"] = 1
+	}
+	top := map[string]interface{}{
+		"info":       info,
+		"created by": "cloud-torrent",
+	}
+	if len(opts.Trackers) > 0 {
+		top["announce"] = opts.Trackers[0]
+		var tiers []interface{}
+		for _, tr := range opts.Trackers {
+			tiers = append(tiers, []string{tr})
+		}
+		top["announce-list"] = tiers
+	}
+	if len(opts.WebSeeds) > 0 {
+		top["url-list"] = opts.WebSeeds
+	}
+	if opts.Comment != "" {
+		top["comment"] = opts.Comment
+	}
+	data, err := bencode.EncodeBytes(top)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ParseMetainfo(data)
+	if err != nil {
+		return nil, err
+	}
+	created := &CreatedTorrent{
+		Name:     meta.Name,
+		InfoHash: meta.InfoHash,
+		Magnet:   "magnet:?xt=urn:btih:" + meta.InfoHash + "&dn=" + url.QueryEscape(meta.Name),
+		Torrent:  data,
+	}
+	for _, tr := range opts.Trackers {
+		created.Magnet += "&tr=" + url.QueryEscape(tr)
+	}
+	if opts.Seed {
+		if created.UID, err = seedInPlace(data, meta, abs); err != nil {
+			return nil, err
+		}
+	}
+	return created, nil
+}
+
+// hashPieces reads the files as one stream and returns the concatenated
+// SHA-1 hashes of its pieces. progress is called with the bytes hashed so
+// far after every piece.
+func hashPieces(abs string, isDir bool, files []MetaFile, pieceLength int64, progress func(done int64)) ([]byte, error) {
+	var pieces []byte
+	var done int64
+	buf := make([]byte, pieceLength)
+	n := 0
+	flush := func() {
+		sum := sha1.Sum(buf[:n])
+		pieces = append(pieces, sum[:]...)
+		done += int64(n)
+		n = 0
+		if progress != nil {
+			progress(done)
+		}
+	}
+	for _, f := range files {
+		p := abs
+		if isDir {
+			p = filepath.Join(abs, filepath.FromSlash(f.Path))
+		}
+		fh, err := os.Open(p)
+		if err != nil {
+			return nil, err
+		}
+		r := io.LimitReader(fh, f.Length)
+		var read int64
+		for read < f.Length {
+			m, err := io.ReadFull(r, buf[n:])
+			n += m
+			read += int64(m)
+			if n == len(buf) {
+				flush()
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				break
+			} else if err != nil {
+				fh.Close()
+				return nil, err
+			}
+		}
+		fh.Close()
+		if read != f.Length {
+			return nil, fmt.Errorf("%s changed while hashing", f.Path)
+		}
+	}
+	if n > 0 {
+		flush()
+	}
+	return pieces, nil
+}
+
+// seedInPlace adds a created torrent whose files are at abs without moving
+// them. Files that belong to another torrent are hardlinked, or copied,
+// into a data directory of its own instead, so removing or moving that
+// torrent leaves this one intact.
+func seedInPlace(data []byte, meta *Metainfo, abs string) (string, error) {
+	if CheckDuplicateTorrent(meta.InfoHash) {
+		return "", ErrTorrentExists
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	link := filepath.Join(Root, "torrents", id)
+	if WithinDir(filepath.Join(Root, "torrents"), abs) {
+		if err := os.MkdirAll(link, 0755); err != nil {
+			return "", err
+		}
+		if err := linkTree(abs, filepath.Join(link, meta.Name)); err != nil {
+			os.RemoveAll(link)
+			return "", err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+			return "", err
+		}
+		if err := os.Symlink(filepath.Dir(abs), link); err != nil {
+			return "", err
+		}
+	}
+	t, err := GetSession().AddTorrent(bytes.NewReader(data), &torrent.AddTorrentOptions{ID: id, Stopped: true})
+	if err != nil {
+		os.RemoveAll(link)
+		return "", err
+	}
+	onTorrentAdded(t, "created:"+ServerPath(abs), nil)
+	return t.ID(), nil
+}
+
+// seededInPlace reports whether the data of a torrent is in a shared
+// directory, the one it was created from or a library, rather than one of
+// its own. Such data must not be moved.
+func seededInPlace(id string) bool {
+	return filepath.Base(TorrentDataDir(id)) != id
+}
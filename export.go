@@ -0,0 +1,331 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+// importIDPattern is what an imported torrent id must look like to be
+// kept, as it names directories.
+var importIDPattern = regexp.MustCompile(`^[0-9a-f]{1,64}$`)
+
+const (
+	exportManifestFile = "manifest.json"
+	exportVersion      = 1
+	maxImportSize      = 256 << 20
+)
+
+// ExportedTorrent is a torrent in an export archive. SavePath is relative
+// to the download directory when the data is inside it.
+type ExportedTorrent struct {
+	ID       string      `json:"id"`
+	InfoHash string      `json:"info_hash"`
+	Name     string      `json:"name"`
+	Torrent  string      `json:"torrent,omitempty"`
+	Magnet   string      `json:"magnet,omitempty"`
+	SavePath string      `json:"save_path"`
+	Paused   bool        `json:"paused,omitempty"`
+	Meta     TorrentMeta `json:"meta"`
+	Seed     *SeedPolicy `json:"seed,omitempty"`
+}
+
+type exportManifest struct {
+	Version    int               `json:"version"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Categories []Category        `json:"categories"`
+	Torrents   []ExportedTorrent `json:"torrents"`
+}
+
+type ImportedTorrent struct {
+	InfoHash  string `json:"info_hash"`
+	Name      string `json:"name"`
+	UID       string `json:"uid,omitempty"`
+	Status    string `json:"status"`
+	Verifying bool   `json:"verifying,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+type ImportResult struct {
+	Categories []string          `json:"categories,omitempty"`
+	Errors     []string          `json:"errors,omitempty"`
+	Torrents   []ImportedTorrent `json:"torrents"`
+}
+
+// ExportTorrents writes a zip archive with a manifest of every torrent and
+// the categories, and the .torrent file of each torrent whose metadata is
+// known.
+func ExportTorrents(w io.Writer) error {
+	torrents := GetTorrents()
+	sort.Slice(torrents, func(i, j int) bool {
+		return GetMeta(torrents[i].ID()).Seq < GetMeta(torrents[j].ID()).Seq
+	})
+	zw := zip.NewWriter(w)
+	manifest := exportManifest{Version: exportVersion, ExportedAt: time.Now(), Categories: GetCategories()}
+	for _, t := range torrents {
+		e := exportTorrent(t)
+		if t.Stats().Status != torrent.DownloadingMetadata {
+			if data, err := t.Torrent(); err == nil {
+				e.Torrent = "torrents/" + e.InfoHash + ".torrent"
+				f, err := zw.Create(e.Torrent)
+				if err != nil {
+					return err
+				}
+				if _, err := f.Write(data); err != nil {
+					return err
+				}
+			}
+		}
+		manifest.Torrents = append(manifest.Torrents, e)
+	}
+	f, err := zw.Create(exportManifestFile)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(manifest); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func exportTorrent(t *torrent.Torrent) ExportedTorrent {
+	id := t.ID()
+	e := ExportedTorrent{
+		ID:       id,
+		InfoHash: strings.ToLower(t.InfoHash().String()),
+		Name:     t.Name(),
+		SavePath: TorrentDataDir(id),
+		Meta:     GetMeta(id),
+	}
+	e.Magnet, _ = t.Magnet()
+	if WithinDir(Root, e.SavePath) {
+		rel, _ := filepath.Rel(Root, e.SavePath)
+		e.SavePath = filepath.ToSlash(rel)
+	}
+	queueMutex.Lock()
+	e.Paused = queueState.Held[id]
+	queueMutex.Unlock()
+	seedMutex.RLock()
+	if p, ok := seedState.Torrents[id]; ok {
+		e.Seed = &p
+	}
+	seedMutex.RUnlock()
+	return e
+}
+
+// ImportTorrents restores an export archive. Torrents already in the
+// session are skipped, missing categories are created, and torrents whose
+// data is found at their save path are verified in place.
+func ImportTorrents(zr *zip.Reader) (*ImportResult, error) {
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+	mf, ok := files[exportManifestFile]
+	if !ok {
+		return nil, fmt.Errorf("no %s in archive", exportManifestFile)
+	}
+	data, err := readZipFile(mf, maxImportSize)
+	if err != nil {
+		return nil, err
+	}
+	var manifest exportManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Version != exportVersion {
+		return nil, fmt.Errorf("unsupported export version %d", manifest.Version)
+	}
+
+	result := &ImportResult{Torrents: []ImportedTorrent{}}
+	for _, c := range manifest.Categories {
+		if _, ok := GetCategory(c.Name); ok {
+			continue
+		}
+		if err := SetCategory(c); err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("category %s: %v", c.Name, err))
+			continue
+		}
+		result.Categories = append(result.Categories, c.Name)
+	}
+	for _, e := range manifest.Torrents {
+		res := ImportedTorrent{InfoHash: e.InfoHash, Name: e.Name}
+		if CheckDuplicateTorrent(e.InfoHash) {
+			res.Status = "skipped"
+			res.Error = ErrTorrentExists.Error()
+		} else {
+			var metainfo []byte
+			var err error
+			if f, ok := files[e.Torrent]; ok && e.Torrent != "" {
+				metainfo, err = readZipFile(f, maxTorrentSize)
+			} else if e.Magnet == "" {
+				err = fmt.Errorf("no metainfo or magnet")
+			}
+			if err == nil {
+				err = importTorrent(e, metainfo, &res)
+			}
+			if err != nil {
+				res.Status = "failed"
+				res.Error = err.Error()
+			} else {
+				res.Status = "imported"
+			}
+		}
+		result.Torrents = append(result.Torrents, res)
+	}
+	return result, nil
+}
+
+func importTorrent(e ExportedTorrent, metainfo []byte, res *ImportedTorrent) error {
+	// Keeping the old id keeps category and data directories named as the
+	// copied data expects. Ids that are not plain hex are replaced.
+	id := e.ID
+	if !importIDPattern.MatchString(id) || GetSession().GetTorrent(id) != nil {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		id = hex.EncodeToString(b)
+	}
+	savePath, err := importSavePath(e.SavePath)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	link, linked := linkImportedData(id, savePath)
+
+	var t *torrent.Torrent
+	opts := &torrent.AddTorrentOptions{ID: id, Stopped: true}
+	if metainfo != nil {
+		t, err = GetSession().AddTorrent(bytes.NewReader(metainfo), opts)
+	} else {
+		t, err = GetSession().AddURI(e.Magnet, opts)
+	}
+	if err != nil {
+		if linked {
+			os.Remove(link)
+		}
+		return err
+	}
+	res.UID = id
+	hasData := e.Name != ""
+	if hasData {
+		_, err := os.Stat(filepath.Join(TorrentDataDir(id), e.Name))
+		hasData = err == nil
+	}
+
+	onTorrentAdded(t, e.Meta.Source, &AddOptions{Label: e.Meta.Label, Notes: e.Meta.Notes, Paused: e.Paused})
+	restoreMeta(id, e.Meta)
+	if cat := e.Meta.Category; cat != "" {
+		if _, ok := GetCategory(cat); !ok {
+			res.Error = fmt.Sprintf("category %s not found", cat)
+		} else if hasData {
+			// The data stays where it was found.
+			setMetaCategory(id, cat)
+		} else if err := SetTorrentCategory(id, cat); err != nil {
+			res.Error = err.Error()
+		}
+	}
+	if e.Seed != nil {
+		if err := SetSeedPolicy(id, e.Seed); err != nil {
+			log.Printf("Could not restore seeding policy of %s: %v", e.Name, err)
+		}
+	}
+	if hasData {
+		if err := RecheckTorrent(id); err != nil {
+			log.Printf("Could not verify imported %s: %v", e.Name, err)
+		} else {
+			res.Verifying = true
+		}
+	}
+	return nil
+}
+
+// importSavePath resolves the save path of an imported torrent, which must
+// be inside Root, symlinks included, and not Root, its torrents directory
+// or the state directory. An empty save path resolves to "".
+func importSavePath(p string) (string, error) {
+	if p == "" {
+		return "", nil
+	}
+	abs := filepath.FromSlash(p)
+	if !filepath.IsAbs(abs) {
+		abs = filepath.Join(Root, abs)
+	}
+	abs = filepath.Clean(abs)
+	resolved := abs
+	if r, err := filepath.EvalSymlinks(abs); err == nil {
+		resolved = r
+	}
+	root := Root
+	if r, err := filepath.EvalSymlinks(Root); err == nil {
+		root = r
+	}
+	torrents := filepath.Join(Root, "torrents")
+	for _, q := range []string{abs, resolved} {
+		if q == Root || q == root || q == torrents || !(WithinDir(Root, q) || WithinDir(root, q)) || WithinDir(StateDir, q) {
+			return "", fmt.Errorf("save path %s is outside the download directory, data not linked", abs)
+		}
+	}
+	return abs, nil
+}
+
+// linkImportedData points rain's data directory of a torrent at its save
+// path when the data is found there, and reports whether it created the
+// link.
+func linkImportedData(id, savePath string) (string, bool) {
+	link := filepath.Join(Root, "torrents", id)
+	fi, err := os.Lstat(link)
+	exists := err == nil
+	if exists && fi.Mode()&os.ModeSymlink == 0 {
+		return link, false
+	}
+	if fi, err := os.Stat(savePath); savePath == "" || savePath == link || err != nil || !fi.IsDir() {
+		// A link copied from the old host may point at its paths.
+		if _, err := os.Stat(link); exists && err != nil {
+			os.Remove(link)
+		}
+		return link, false
+	}
+	if exists {
+		if target, _ := os.Readlink(link); target == savePath {
+			return link, false
+		}
+		os.Remove(link)
+	}
+	if err := os.MkdirAll(filepath.Dir(link), 0755); err != nil {
+		return link, false
+	}
+	if err := os.Symlink(savePath, link); err != nil {
+		log.Printf("Could not link imported data %s: %v", savePath, err)
+		return link, false
+	}
+	return link, true
+}
+
+func readZipFile(f *zip.File, limit int64) ([]byte, error) {
+	if f.UncompressedSize64 > uint64(limit) {
+		return nil, fmt.Errorf("%s too large", f.Name)
+	}
+	r, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(io.LimitReader(r, limit))
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestImportIDPattern(t *testing.T) {
+	for id, want := range map[string]bool{
+		"0123456789abcdef0123456789abcdef": true,
+		"":                                 false,
+		"../../etc":                        false,
+		"ABCDEF":                           false,
+		"abc/def":                          false,
+	} {
+		if got := importIDPattern.MatchString(id); got != want {
+			t.Errorf("id %q accepted %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestImportSavePath(t *testing.T) {
+	for _, p := range []string{"torrents/abc", "tv", filepath.Join(Root, "movies")} {
+		if _, err := importSavePath(p); err != nil {
+			t.Errorf("save path %q rejected: %v", p, err)
+		}
+	}
+	for _, p := range []string{".", "..", "../elsewhere", "/etc", "torrents", ".cloudtorrent/x", filepath.Join(Root+"X", "y")} {
+		if _, err := importSavePath(p); err == nil {
+			t.Errorf("save path %q accepted", p)
+		}
+	}
+	if p, err := importSavePath(""); p != "" || err != nil {
+		t.Errorf("empty save path resolved to %q, %v", p, err)
+	}
+}
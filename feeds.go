@@ -0,0 +1,478 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const feedsStateFile = "feeds.json"
+
+// feedFixturesDir holds the local feeds file:// URLs may read.
+func feedFixturesDir() string {
+	return filepath.Join(StateDir, "feeds")
+}
+
+// feedMemory is how long downloaded items and episodes are remembered.
+const feedMemory = 180 * 24 * time.Hour
+
+// Feed is an RSS or Atom feed polled every Minutes. Items matching one of
+// its rules are added, at most once per item and, for rules tracking
+// episodes, once per episode.
+type Feed struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name"`
+	URL         string     `json:"url"`
+	Minutes     int        `json:"minutes"`
+	Disabled    bool       `json:"disabled,omitempty"`
+	Rules       []FeedRule `json:"rules"`
+	LastChecked time.Time  `json:"last_checked,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+}
+
+// FeedRule matches item titles against Include and, when set, not against
+// Exclude. Both are case-insensitive regular expressions.
+type FeedRule struct {
+	Name     string `json:"name"`
+	Include  string `json:"include"`
+	Exclude  string `json:"exclude,omitempty"`
+	Category string `json:"category,omitempty"`
+	Episodes bool   `json:"episodes,omitempty"`
+}
+
+type FeedItem struct {
+	Title   string `json:"title"`
+	Link    string `json:"link"`
+	GUID    string `json:"guid"`
+	Episode string `json:"episode,omitempty"`
+}
+
+// FeedMatch is the verdict of a rule on a single item.
+type FeedMatch struct {
+	FeedItem
+	Rule       string `json:"rule,omitempty"`
+	Matched    bool   `json:"matched"`
+	Downloaded bool   `json:"downloaded,omitempty"`
+}
+
+var (
+	feedsState = struct {
+		Feeds      map[string]*Feed     `json:"feeds"`
+		Downloaded map[string]time.Time `json:"downloaded"`
+	}{
+		Feeds:      make(map[string]*Feed),
+		Downloaded: make(map[string]time.Time),
+	}
+	feedsMutex sync.Mutex
+	episodeRe  = regexp.MustCompile(`(?i)\bS(\d{1,3})[ ._-]?E(\d{1,3})\b|\b(\d{1,2})x(\d{2,3})\b`)
+)
+
+func InitFeeds() {
+	feedsMutex.Lock()
+	if err := loadState(feedsStateFile, &feedsState); err != nil {
+		log.Printf("Could not load feeds: %v", err)
+	}
+	if feedsState.Feeds == nil {
+		feedsState.Feeds = make(map[string]*Feed)
+	}
+	if feedsState.Downloaded == nil {
+		feedsState.Downloaded = make(map[string]time.Time)
+	}
+	feedsMutex.Unlock()
+	go pollFeeds()
+}
+
+func saveFeedsState() {
+	if err := saveState(feedsStateFile, &feedsState); err != nil {
+		log.Printf("Could not save feeds: %v", err)
+	}
+}
+
+func (r FeedRule) compile() (include, exclude *regexp.Regexp, err error) {
+	if include, err = regexp.Compile("(?i)" + r.Include); err != nil {
+		return nil, nil, fmt.Errorf("rule %q: %v", r.Name, err)
+	}
+	if r.Exclude != "" {
+		if exclude, err = regexp.Compile("(?i)" + r.Exclude); err != nil {
+			return nil, nil, fmt.Errorf("rule %q: %v", r.Name, err)
+		}
+	}
+	return include, exclude, nil
+}
+
+func (r FeedRule) Validate() error {
+	if r.Name == "" || r.Include == "" {
+		return fmt.Errorf("rules need a name and an include pattern")
+	}
+	if r.Category != "" {
+		if _, ok := GetCategory(r.Category); !ok {
+			return fmt.Errorf("category %q not found", r.Category)
+		}
+	}
+	_, _, err := r.compile()
+	return err
+}
+
+func (r FeedRule) matches(title string) bool {
+	include, exclude, err := r.compile()
+	if err != nil {
+		return false
+	}
+	return include.MatchString(title) && (exclude == nil || !exclude.MatchString(title))
+}
+
+func validFeedURL(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	if u.Scheme == "file" {
+		_, err = feedFile(u)
+		return err == nil
+	}
+	return u.Scheme == "http" || u.Scheme == "https"
+}
+
+// feedFile returns the file a file:// feed URL names, which must be in
+// feedFixturesDir.
+func feedFile(u *url.URL) (string, error) {
+	p := filepath.Clean(u.Path)
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		p = resolved
+	}
+	dir := feedFixturesDir()
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	if !filepath.IsAbs(p) || p == dir || !WithinDir(dir, p) {
+		return "", fmt.Errorf("file feeds must be in %s", feedFixturesDir())
+	}
+	return p, nil
+}
+
+func GetFeeds() []Feed {
+	feedsMutex.Lock()
+	defer feedsMutex.Unlock()
+	list := []Feed{}
+	for _, f := range feedsState.Feeds {
+		list = append(list, *f)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// SetFeed creates a feed, or replaces the settings of an existing one when
+// its ID is set.
+func SetFeed(f Feed) (Feed, error) {
+	f.Name = strings.TrimSpace(f.Name)
+	if f.Name == "" {
+		f.Name = f.URL
+	}
+	if !validFeedURL(f.URL) {
+		return f, fmt.Errorf("invalid feed url")
+	}
+	if f.Minutes < 1 {
+		return f, fmt.Errorf("interval must be at least one minute")
+	}
+	for _, r := range f.Rules {
+		if err := r.Validate(); err != nil {
+			return f, err
+		}
+	}
+	feedsMutex.Lock()
+	defer feedsMutex.Unlock()
+	if f.ID == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		f.ID = hex.EncodeToString(b)
+	} else if old, ok := feedsState.Feeds[f.ID]; ok {
+		f.LastChecked, f.LastError = old.LastChecked, old.LastError
+	} else {
+		return f, fmt.Errorf("feed not found")
+	}
+	feedsState.Feeds[f.ID] = &f
+	saveFeedsState()
+	return f, nil
+}
+
+func RemoveFeed(id string) error {
+	feedsMutex.Lock()
+	defer feedsMutex.Unlock()
+	if _, ok := feedsState.Feeds[id]; !ok {
+		return fmt.Errorf("feed not found")
+	}
+	delete(feedsState.Feeds, id)
+	prefix := id + "|"
+	for key := range feedsState.Downloaded {
+		if strings.HasPrefix(key, prefix) {
+			delete(feedsState.Downloaded, key)
+		}
+	}
+	saveFeedsState()
+	return nil
+}
+
+// TestFeedRule matches the current items of a feed against a rule without
+// downloading anything. An empty feedID tests against uri instead.
+func TestFeedRule(feedID, uri string, rule FeedRule) ([]FeedMatch, error) {
+	if rule.Name == "" {
+		rule.Name = "test"
+	}
+	if _, _, err := rule.compile(); err != nil {
+		return nil, err
+	}
+	if feedID != "" {
+		feedsMutex.Lock()
+		f, ok := feedsState.Feeds[feedID]
+		if ok {
+			uri = f.URL
+		}
+		feedsMutex.Unlock()
+		if !ok {
+			return nil, fmt.Errorf("feed not found")
+		}
+	}
+	if !validFeedURL(uri) {
+		return nil, fmt.Errorf("invalid feed url")
+	}
+	items, err := FetchFeed(uri)
+	if err != nil {
+		return nil, err
+	}
+	feedsMutex.Lock()
+	defer feedsMutex.Unlock()
+	matches := []FeedMatch{}
+	for _, item := range items {
+		m := FeedMatch{FeedItem: item, Matched: rule.matches(item.Title)}
+		if m.Matched {
+			m.Rule = rule.Name
+			m.Downloaded = feedItemSeen(feedID, rule, item)
+		}
+		matches = append(matches, m)
+	}
+	return matches, nil
+}
+
+func pollFeeds() {
+	for {
+		feedsMutex.Lock()
+		var due []Feed
+		for _, f := range feedsState.Feeds {
+			if !f.Disabled && time.Since(f.LastChecked) >= time.Duration(f.Minutes)*time.Minute {
+				due = append(due, *f)
+			}
+		}
+		for key, at := range feedsState.Downloaded {
+			if time.Since(at) > feedMemory {
+				delete(feedsState.Downloaded, key)
+			}
+		}
+		feedsMutex.Unlock()
+		for _, f := range due {
+			checkFeed(f)
+		}
+		time.Sleep(time.Minute)
+	}
+}
+
+// checkFeed adds the new items of a feed matching its rules.
+func checkFeed(f Feed) {
+	items, err := FetchFeed(f.URL)
+	feedsMutex.Lock()
+	if stored, ok := feedsState.Feeds[f.ID]; ok {
+		stored.LastChecked = time.Now()
+		stored.LastError = ""
+		if err != nil {
+			stored.LastError = err.Error()
+		}
+	}
+	saveFeedsState()
+	feedsMutex.Unlock()
+	if err != nil {
+		log.Printf("Could not fetch feed %s: %v", f.Name, err)
+		return
+	}
+	for _, item := range items {
+		for _, rule := range f.Rules {
+			if !rule.matches(item.Title) {
+				continue
+			}
+			feedsMutex.Lock()
+			seen := feedItemSeen(f.ID, rule, item)
+			feedsMutex.Unlock()
+			if seen {
+				break
+			}
+			opts := &AddOptions{Category: rule.Category, Source: "feed:" + f.Name}
+			if _, err := AddTorrent(item.Link, opts); err == nil {
+				log.Printf("Added %q from feed %s (rule %s)", item.Title, f.Name, rule.Name)
+				BroadcastMessage("torrent_added", map[string]string{"status": "ok"})
+			} else if err != ErrTorrentExists {
+				log.Printf("Could not add %q from feed %s: %v", item.Title, f.Name, err)
+				break
+			}
+			feedsMutex.Lock()
+			rememberFeedItem(f.ID, rule, item, time.Now())
+			saveFeedsState()
+			feedsMutex.Unlock()
+			break
+		}
+	}
+}
+
+// feedItemSeen reports whether an item, or for rules tracking episodes
+// its episode, was already downloaded from a feed. feedsMutex must be
+// held.
+func feedItemSeen(feedID string, rule FeedRule, item FeedItem) bool {
+	if _, ok := feedsState.Downloaded[feedID+"|item|"+item.GUID]; ok {
+		return true
+	}
+	if rule.Episodes && item.Episode != "" {
+		_, ok := feedsState.Downloaded[feedID+"|"+rule.Name+"|"+item.Episode]
+		return ok
+	}
+	return false
+}
+
+// rememberFeedItem records that an item was downloaded by rule, so neither
+// it nor its episode is added again. feedsMutex must be held.
+func rememberFeedItem(feedID string, rule FeedRule, item FeedItem, at time.Time) {
+	feedsState.Downloaded[feedID+"|item|"+item.GUID] = at
+	if rule.Episodes && item.Episode != "" {
+		feedsState.Downloaded[feedID+"|"+rule.Name+"|"+item.Episode] = at
+	}
+}
+
+// FetchFeed downloads and parses an RSS or Atom feed. file:// URLs are
+// read from feedFixturesDir.
+func FetchFeed(uri string) ([]FeedItem, error) {
+	var data []byte
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "file" {
+		var p string
+		if p, err = feedFile(u); err == nil {
+			data, err = ioutil.ReadFile(p)
+		}
+	} else {
+		var resp *http.Response
+		if resp, err = hClient.Get(uri); err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("fetching feed: %s", resp.Status)
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(resp.Body, maxTorrentSize))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return parseFeed(data)
+}
+
+type rssDoc struct {
+	Items []struct {
+		Title     string `xml:"title"`
+		Link      string `xml:"link"`
+		GUID      string `xml:"guid"`
+		Enclosure struct {
+			URL string `xml:"url,attr"`
+		} `xml:"enclosure"`
+		Attrs []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:"value,attr"`
+		} `xml:"attr"`
+	} `xml:"channel>item"`
+}
+
+type atomDoc struct {
+	Entries []struct {
+		Title string `xml:"title"`
+		ID    string `xml:"id"`
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+// parseFeed reads RSS 2.0 and Atom feeds. An item's link is its magnet if
+// it has one, then its enclosure, then its plain link.
+func parseFeed(data []byte) ([]FeedItem, error) {
+	var items []FeedItem
+	if bytes.Contains(data, []byte("<feed")) {
+		var doc atomDoc
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid feed: %v", err)
+		}
+		for _, e := range doc.Entries {
+			item := FeedItem{Title: strings.TrimSpace(e.Title), GUID: e.ID}
+			for _, l := range e.Links {
+				if strings.HasPrefix(l.Href, "magnet:") || l.Rel == "enclosure" || l.Type == "application/x-bittorrent" || item.Link == "" {
+					item.Link = l.Href
+				}
+				if strings.HasPrefix(l.Href, "magnet:") {
+					break
+				}
+			}
+			items = append(items, item)
+		}
+	} else {
+		var doc rssDoc
+		if err := xml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("invalid feed: %v", err)
+		}
+		for _, i := range doc.Items {
+			item := FeedItem{Title: strings.TrimSpace(i.Title), Link: strings.TrimSpace(i.Link), GUID: strings.TrimSpace(i.GUID)}
+			if i.Enclosure.URL != "" && !strings.HasPrefix(item.Link, "magnet:") {
+				item.Link = i.Enclosure.URL
+			}
+			for _, a := range i.Attrs {
+				if a.Name == "magneturl" && a.Value != "" {
+					item.Link = a.Value
+				}
+			}
+			items = append(items, item)
+		}
+	}
+	list := []FeedItem{}
+	for _, item := range items {
+		if item.Link == "" {
+			continue
+		}
+		if item.GUID == "" {
+			item.GUID = item.Link
+		}
+		if m := episodeRe.FindStringSubmatch(item.Title); m != nil {
+			season, episode := m[1], m[2]
+			if season == "" {
+				season, episode = m[3], m[4]
+			}
+			s, _ := strconv.Atoi(season)
+			e, _ := strconv.Atoi(episode)
+			item.Episode = fmt.Sprintf("S%02dE%02d", s, e)
+		}
+		list = append(list, item)
+	}
+	return list, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readFeedFixture(t *testing.T, name string) []FeedItem {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := parseFeed(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return items
+}
+
+func TestParseFeed(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		want []FeedItem
+	}{
+		{"rss.xml", []FeedItem{
+			{Title: "Show.Name.S01E02.720p.WEB", Link: "https://example.com/1.torrent", GUID: "item-1", Episode: "S01E02"},
+			{Title: "Show Name 1x03 1080p", Link: "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567", GUID: "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567", Episode: "S01E03"},
+			{Title: "Other.Show.S02E10.CAM", Link: "magnet:?xt=urn:btih:89abcdef0123456789abcdef0123456789abcdef", GUID: "item-3", Episode: "S02E10"},
+		}},
+		{"atom.xml", []FeedItem{
+			{Title: "Documentary 2024", Link: "https://example.com/a.torrent", GUID: "urn:entry:1"},
+			{Title: "Series S03E04", Link: "magnet:?xt=urn:btih:fedcba9876543210fedcba9876543210fedcba98", GUID: "urn:entry:2", Episode: "S03E04"},
+			{Title: "Plain page", Link: "https://example.com/page/3", GUID: "urn:entry:3"},
+		}},
+	} {
+		items := readFeedFixture(t, tc.file)
+		if len(items) != len(tc.want) {
+			t.Errorf("%s: got %d items %+v, want %d", tc.file, len(items), items, len(tc.want))
+			continue
+		}
+		for i, want := range tc.want {
+			if items[i] != want {
+				t.Errorf("%s item %d: got %+v, want %+v", tc.file, i, items[i], want)
+			}
+		}
+	}
+}
+
+func TestParseFeedInvalid(t *testing.T) {
+	for _, data := range []string{"<rss><channel><item>", "<feed><entry>"} {
+		if _, err := parseFeed([]byte(data)); err == nil {
+			t.Errorf("%q: expected an error", data)
+		}
+	}
+}
+
+func TestFeedRuleMatches(t *testing.T) {
+	for _, tc := range []struct {
+		rule  FeedRule
+		title string
+		want  bool
+	}{
+		{FeedRule{Include: `show\.name`}, "Show.Name.S01E02.720p", true},
+		{FeedRule{Include: `show\.name`}, "Other.Show.S01E02", false},
+		{FeedRule{Include: `show`, Exclude: `cam|ts`}, "Other.Show.S02E10.CAM", false},
+		{FeedRule{Include: `show`, Exclude: `cam|ts`}, "Show.Name.S01E02.720p", true},
+		{FeedRule{Include: `1080p`, Exclude: ``}, "Show Name 1x03 1080P", true},
+		{FeedRule{Include: `(`}, "anything", false},
+	} {
+		if got := tc.rule.matches(tc.title); got != tc.want {
+			t.Errorf("%+v on %q: got %v, want %v", tc.rule, tc.title, got, tc.want)
+		}
+	}
+}
+
+func TestFeedEpisodeMemory(t *testing.T) {
+	saved := feedsState.Downloaded
+	feedsState.Downloaded = make(map[string]time.Time)
+	defer func() { feedsState.Downloaded = saved }()
+
+	episodes := FeedRule{Name: "show", Include: "show", Episodes: true}
+	items := FeedRule{Name: "any", Include: "show"}
+	first := FeedItem{Title: "Show.S01E02.720p", GUID: "a", Episode: "S01E02"}
+	repack := FeedItem{Title: "Show.S01E02.1080p", GUID: "b", Episode: "S01E02"}
+	next := FeedItem{Title: "Show.S01E03.720p", GUID: "c", Episode: "S01E03"}
+
+	rememberFeedItem("feed", episodes, first, time.Now())
+	for _, tc := range []struct {
+		feed string
+		rule FeedRule
+		item FeedItem
+		want bool
+	}{
+		{"feed", episodes, first, true},
+		{"feed", episodes, repack, true},
+		{"feed", episodes, next, false},
+		// without episode tracking only the item itself is remembered
+		{"feed", items, first, true},
+		{"feed", items, repack, false},
+		// memory is kept per feed and per rule
+		{"other", episodes, repack, false},
+		{"feed", FeedRule{Name: "other", Include: "show", Episodes: true}, repack, false},
+	} {
+		if got := feedItemSeen(tc.feed, tc.rule, tc.item); got != tc.want {
+			t.Errorf("%s/%s %s: seen %v, want %v", tc.feed, tc.rule.Name, tc.item.Title, got, tc.want)
+		}
+	}
+}
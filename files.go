@@ -0,0 +1,43 @@
+package main
+
+import (
+	"fmt"
+	"path"
+)
+
+type TorrentFile struct {
+	Index    int    `json:"index"`
+	Name     string `json:"name,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Size     string `json:"size,omitempty"`
+	Perc     string `json:"perc,omitempty"`
+	Progress string `json:"progress,omitempty"`
+}
+
+// GetTorrentFiles lists the files of a torrent with their progress.
+func GetTorrentFiles(id string) ([]TorrentFile, error) {
+	t := GetSession().GetTorrent(id)
+	if t == nil {
+		return nil, ErrTorrentNotFound
+	}
+	stats, err := t.FileStats()
+	if err != nil {
+		return nil, err
+	}
+	files := []TorrentFile{}
+	for i, fs := range stats {
+		perc := "0%"
+		if fs.File.Length() > 0 {
+			perc = fmt.Sprintf("%.2f", float64(fs.BytesCompleted)/float64(fs.File.Length())*100) + "%"
+		}
+		files = append(files, TorrentFile{
+			Index:    i,
+			Name:     path.Base(fs.File.Path()),
+			Path:     fs.File.Path(),
+			Size:     ByteCountSI(fs.File.Length()),
+			Perc:     perc,
+			Progress: GetProgress(perc),
+		})
+	}
+	return files, nil
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,7 +12,9 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -18,24 +22,143 @@ import (
 // Gin Handlers
 
 func AddTorrentHandler(c *gin.Context) {
+	var ok bool
+	var err error
+	opts := parseAddOptions(c)
+	if fh, ferr := c.FormFile("torrent"); ferr == nil {
+		var data []byte
+		if data, err = readFormFile(fh, maxTorrentSize); err == nil {
+			ok, err = AddTorrentByFile(data, opts)
+		}
+	} else if uri := c.PostForm("url"); uri != "" {
+		ok, err = AddTorrentByURL(uri, opts)
+	} else if magnet := c.PostForm("magnet"); magnet != "" {
+		ok, err = AddTorrent(magnet, opts)
+	} else {
+		c.String(http.StatusBadRequest, "No magnet, url or torrent file provided")
+		return
+	}
+	if err == ErrTorrentExists || (err == nil && !ok) {
+		c.String(http.StatusBadRequest, "Torrent already exists")
+		return
+	} else if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	BroadcastMessage("torrent_added", map[string]string{"status": "ok"})
+	c.Status(http.StatusOK)
+}
+
+func CreateTorrentHandler(c *gin.Context) {
+	opts := CreateOptions{
+		Path:     c.PostForm("path"),
+		Trackers: SplitList(strings.Replace(c.PostForm("trackers"), "\n", ",", -1)),
+		WebSeeds: SplitList(strings.Replace(c.PostForm("web_seeds"), "\n", ",", -1)),
+		Private:  c.PostForm("private") == "true",
+		Comment:  c.PostForm("comment"),
+		Seed:     c.PostForm("seed") == "true",
+	}
+	if opts.Path == "" {
+		c.String(http.StatusBadRequest, "No path provided")
+		return
+	}
+	if size := c.DefaultPostForm("piece_size", "auto"); size != "auto" {
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil || n <= 0 {
+			c.String(http.StatusBadRequest, "Invalid piece size")
+			return
+		}
+		opts.PieceLength = n
+	}
+	job, err := StartCreateTorrent(opts)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+func GetCreateJobHandler(c *gin.Context) {
+	job, ok := GetCreateJob(c.Query("id"))
+	if !ok {
+		c.String(http.StatusNotFound, "Job not found")
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+func InspectMagnetHandler(c *gin.Context) {
 	magnet := c.PostForm("magnet")
 	if magnet == "" {
 		c.String(http.StatusBadRequest, "No magnet provided")
 		return
 	}
-	if ok, err := AddTorrentByMagnet(magnet); err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+	seconds, _ := strconv.Atoi(c.PostForm("timeout"))
+	info, err := InspectMagnet(magnet, time.Duration(seconds)*time.Second)
+	if err == ErrInspectTimeout {
+		c.String(http.StatusGatewayTimeout, err.Error())
 		return
-	} else if !ok {
-		c.String(http.StatusBadRequest, "Torrent already exists")
+	} else if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, info)
+}
+
+func ExportHandler(c *gin.Context) {
+	name := "cloudtorrent-" + time.Now().Format("20060102-150405") + ".zip"
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", "attachment; filename="+name)
+	if err := ExportTorrents(c.Writer); err != nil {
+		log.Printf("Could not export torrents: %v", err)
+	}
+}
+
+func ImportHandler(c *gin.Context) {
+	fh, err := c.FormFile("archive")
+	if err != nil {
+		c.String(http.StatusBadRequest, "No archive provided")
+		return
+	}
+	data, err := readFormFile(fh, maxImportSize)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid archive: "+err.Error())
+		return
+	}
+	result, err := ImportTorrents(zr)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
 	BroadcastMessage("torrent_added", map[string]string{"status": "ok"})
-	c.Status(http.StatusOK)
+	c.JSON(http.StatusOK, result)
+}
+
+func parseAddOptions(c *gin.Context) *AddOptions {
+	opts := &AddOptions{
+		Category: c.PostForm("category"),
+		Label:    c.PostForm("label"),
+		Notes:    c.PostForm("notes"),
+	}
+	return opts
 }
 
 func ActiveTorrentsHandler(c *gin.Context) {
 	torrents := GetAllTorrents()
+	if category, ok := c.GetQuery("category"); ok {
+		filtered := []TorrentData{}
+		for _, t := range torrents {
+			if t.Category == category {
+				filtered = append(filtered, t)
+			}
+		}
+		torrents = filtered
+	}
 	c.JSON(http.StatusOK, torrents)
 }
 
@@ -53,23 +176,575 @@ func GetTorrentHandler(c *gin.Context) {
 	c.JSON(http.StatusOK, torrent)
 }
 
-func DeleteTorrentHandler(c *gin.Context) {
+func GetTorrentFilesHandler(c *gin.Context) {
+	id := c.Query("uid")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No uid provided")
+		return
+	}
+	files, err := GetTorrentFiles(id)
+	if err == ErrTorrentNotFound {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
+
+func SetTorrentMetaHandler(c *gin.Context) {
 	id := c.PostForm("uid")
 	if id == "" {
 		c.String(http.StatusBadRequest, "No uid provided")
 		return
 	}
-	if ok, err := DeleteTorrentByID(id); err != nil {
+	var label, notes *string
+	if v, ok := c.GetPostForm("label"); ok {
+		label = &v
+	}
+	if v, ok := c.GetPostForm("notes"); ok {
+		notes = &v
+	}
+	if err := SetMetaInfo(id, label, notes); err == ErrTorrentNotFound {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
 		c.String(http.StatusInternalServerError, err.Error())
 		return
-	} else if !ok {
+	}
+	c.Status(http.StatusOK)
+}
+
+func SetTorrentCategoryHandler(c *gin.Context) {
+	id := c.PostForm("uid")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No uid provided")
+		return
+	}
+	if err := SetTorrentCategory(id, c.PostForm("category")); err == ErrTorrentNotFound {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func GetCategoriesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, GetCategories())
+}
+
+func SetCategoryHandler(c *gin.Context) {
+	cat := Category{
+		Name:        c.PostForm("name"),
+		Dir:         c.PostForm("dir"),
+		Library:     c.PostForm("library"),
+		LibraryMode: c.PostForm("library_mode"),
+	}
+	if mode := c.PostForm("seed_mode"); mode != "" {
+		cat.Seed = &SeedPolicy{Mode: mode}
+		cat.Seed.Ratio, _ = strconv.ParseFloat(c.PostForm("seed_ratio"), 64)
+		cat.Seed.Hours, _ = strconv.ParseFloat(c.PostForm("seed_hours"), 64)
+	}
+	if err := SetCategory(cat); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func RemoveCategoryHandler(c *gin.Context) {
+	name := c.PostForm("name")
+	if name == "" {
+		c.String(http.StatusBadRequest, "No name provided")
+		return
+	}
+	if err := RemoveCategory(name); err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func GetFeedsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, GetFeeds())
+}
+
+func SetFeedHandler(c *gin.Context) {
+	var feed Feed
+	if err := c.ShouldBindJSON(&feed); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	feed, err := SetFeed(feed)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, feed)
+}
+
+func RemoveFeedHandler(c *gin.Context) {
+	id := c.PostForm("id")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No id provided")
+		return
+	}
+	if err := RemoveFeed(id); err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func TestFeedRuleHandler(c *gin.Context) {
+	var req struct {
+		ID   string   `json:"id"`
+		URL  string   `json:"url"`
+		Rule FeedRule `json:"rule"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	matches, err := TestFeedRule(req.ID, req.URL, req.Rule)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, matches)
+}
+
+func GetHooksHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, GetHooks())
+}
+
+func GetHookHistoryHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, GetHookHistory())
+}
+
+func GetWebhooksHandler(c *gin.Context) {
+	list := []WebhookInfo{}
+	for _, w := range GetWebhooks() {
+		list = append(list, w.info())
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func SetWebhookHandler(c *gin.Context) {
+	var req struct {
+		Webhook
+		ClearSecret bool `json:"clear_secret"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	hook, err := SetWebhook(req.Webhook, req.ClearSecret)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, hook.info())
+}
+
+func RemoveWebhookHandler(c *gin.Context) {
+	id := c.PostForm("id")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No id provided")
+		return
+	}
+	if err := RemoveWebhook(id); err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func TestWebhookHandler(c *gin.Context) {
+	id := c.PostForm("id")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No id provided")
+		return
+	}
+	if err := TestWebhook(id); err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func GetWebhookDeliveriesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, GetWebhookDeliveries())
+}
+
+func GetWatchHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, GetWatchConfig())
+}
+
+func SetWatchHandler(c *gin.Context) {
+	cfg := GetWatchConfig()
+	if v, ok := c.GetPostForm("dir"); ok {
+		cfg.Dir = v
+	}
+	if v, ok := c.GetPostForm("seconds"); ok {
+		cfg.Seconds, _ = strconv.Atoi(v)
+	}
+	if err := SetWatchConfig(cfg); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func GetSettingsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, GetSessionSettings())
+}
+
+func SetSettingsHandler(c *gin.Context) {
+	s := GetSessionSettings()
+	if v, ok := c.GetPostForm("port_begin"); ok {
+		n, _ := strconv.ParseUint(v, 10, 16)
+		s.PortBegin = uint16(n)
+	}
+	if v, ok := c.GetPostForm("port_end"); ok {
+		n, _ := strconv.ParseUint(v, 10, 16)
+		s.PortEnd = uint16(n)
+	}
+	if v, ok := c.GetPostForm("dht"); ok {
+		s.DHT, _ = strconv.ParseBool(v)
+	}
+	if v, ok := c.GetPostForm("pex"); ok {
+		s.PEX, _ = strconv.ParseBool(v)
+	}
+	if v, ok := c.GetPostForm("encryption"); ok {
+		s.Encryption = v
+	}
+	if v, ok := c.GetPostForm("max_peer_dial"); ok {
+		s.MaxPeerDial, _ = strconv.Atoi(v)
+	}
+	if v, ok := c.GetPostForm("max_peer_accept"); ok {
+		s.MaxPeerAccept, _ = strconv.Atoi(v)
+	}
+	if v, ok := c.GetPostForm("max_open_files"); ok {
+		s.MaxOpenFiles, _ = strconv.ParseUint(v, 10, 64)
+	}
+	if err := SetSessionSettings(s); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, GetSessionSettings())
+}
+
+func GetBlocklistHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, GetBlocklistStatus())
+}
+
+func SetBlocklistHandler(c *gin.Context) {
+	cfg := GetBlocklistStatus().BlocklistConfig
+	if v, ok := c.GetPostForm("source"); ok {
+		cfg.Source = v
+	}
+	if v, ok := c.GetPostForm("hours"); ok {
+		cfg.Hours, _ = strconv.ParseFloat(v, 64)
+	}
+	if err := SetBlocklistConfig(cfg); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, GetBlocklistStatus())
+}
+
+func ReloadBlocklistHandler(c *gin.Context) {
+	if err := ReloadBlocklist(); err != nil {
+		c.String(http.StatusBadGateway, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, GetBlocklistStatus())
+}
+
+func GetQueueHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, GetQueueConfig())
+}
+
+func SetQueueHandler(c *gin.Context) {
+	cfg := GetQueueConfig()
+	if v, ok := c.GetPostForm("max_downloads"); ok {
+		cfg.MaxDownloads, _ = strconv.Atoi(v)
+	}
+	if v, ok := c.GetPostForm("max_seeds"); ok {
+		cfg.MaxSeeds, _ = strconv.Atoi(v)
+	}
+	if err := SetQueueConfig(cfg); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func MoveInQueueHandler(c *gin.Context) {
+	id := c.PostForm("uid")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No uid provided")
+		return
+	}
+	if err := MoveInQueue(id, c.PostForm("direction")); err == ErrTorrentNotFound {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func SetStreamingHandler(c *gin.Context) {
+	id := c.PostForm("uid")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No uid provided")
+		return
+	}
+	enabled, err := strconv.ParseBool(c.PostForm("enabled"))
+	if err != nil {
+		c.String(http.StatusBadRequest, "Invalid enabled value")
+		return
+	}
+	if err := SetStreaming(id, enabled); err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func GetTrackerSourceHandler(c *gin.Context) {
+	trackersMutex.RLock()
+	count := len(Trackers)
+	trackersMutex.RUnlock()
+	c.JSON(http.StatusOK, gin.H{"source": GetTrackerSource(), "count": count})
+}
+
+func SetTrackerSourceHandler(c *gin.Context) {
+	src := GetTrackerSource()
+	if v, ok := c.GetPostForm("url"); ok {
+		src.URL = v
+	}
+	if v, ok := c.GetPostForm("hours"); ok {
+		src.Hours, _ = strconv.ParseFloat(v, 64)
+	}
+	if err := SetTrackerSource(src); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func GetTorrentPeersHandler(c *gin.Context) {
+	id := c.Query("uid")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No uid provided")
+		return
+	}
+	peers, err := GetTorrentPeers(id)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, peers)
+}
+
+func GetPieceMapHandler(c *gin.Context) {
+	id := c.Query("uid")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No uid provided")
+		return
+	}
+	pieces, err := GetPieceMap(id)
+	if err == ErrTorrentNotFound {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		c.String(http.StatusConflict, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, pieces)
+}
+
+func RecheckHandler(c *gin.Context) {
+	id := c.PostForm("uid")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No uid provided")
+		return
+	}
+	if err := RecheckTorrent(id); err == ErrTorrentNotFound {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		c.String(http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func GetTorrentTrackersHandler(c *gin.Context) {
+	id := c.Query("uid")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No uid provided")
+		return
+	}
+	trackers, err := GetTorrentTrackers(id)
+	if err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, trackers)
+}
+
+func AddTorrentTrackerHandler(c *gin.Context) {
+	torrentTrackerAction(c, AddTorrentTracker)
+}
+
+func torrentTrackerAction(c *gin.Context, action func(id, uri string) error) {
+	id, uri := c.PostForm("uid"), c.PostForm("url")
+	if id == "" || uri == "" {
+		c.String(http.StatusBadRequest, "No uid or url provided")
+		return
+	}
+	if err := action(id, uri); err == ErrTorrentNotFound {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func ReannounceHandler(c *gin.Context) {
+	id := c.PostForm("uid")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No uid provided")
+		return
+	}
+	if err := ReannounceTorrent(id); err != nil {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func GetSeedPolicyHandler(c *gin.Context) {
+	id := c.Query("uid")
+	if id != "" && GetSession().GetTorrent(id) == nil {
 		c.String(http.StatusNotFound, "Torrent not found")
 		return
 	}
-	BroadcastMessage("torrent_removed", map[string]string{"uid": id})
+	c.JSON(http.StatusOK, GetSeedPolicy(id))
+}
+
+// SetSeedPolicyHandler sets the global policy when no uid is given. For a
+// single torrent the mode "default" drops its override.
+func SetSeedPolicyHandler(c *gin.Context) {
+	id := c.PostForm("uid")
+	mode := c.PostForm("mode")
+	if mode == "" {
+		c.String(http.StatusBadRequest, "No mode provided")
+		return
+	}
+	var err error
+	if id != "" && mode == "default" {
+		err = SetSeedPolicy(id, nil)
+	} else {
+		p := SeedPolicy{Mode: mode}
+		p.Ratio, _ = strconv.ParseFloat(c.PostForm("ratio"), 64)
+		p.Hours, _ = strconv.ParseFloat(c.PostForm("hours"), 64)
+		if id == "" {
+			err = SetGlobalSeedPolicy(p)
+		} else {
+			err = SetSeedPolicy(id, &p)
+		}
+	}
+	if err == ErrTorrentNotFound {
+		c.String(http.StatusNotFound, err.Error())
+		return
+	} else if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
 	c.Status(http.StatusOK)
 }
 
+func GetSpeedLimitsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"limits": GetBandwidthLimits(),
+		"active": ActiveSpeedLimit(time.Now()),
+	})
+}
+
+// SetSpeedLimitHandler sets the global limit in KiB/s.
+func SetSpeedLimitHandler(c *gin.Context) {
+	if c.PostForm("uid") != "" {
+		c.String(http.StatusBadRequest, "Per-torrent speed limits are not supported")
+		return
+	}
+	var l SpeedLimit
+	var err error
+	if l.Download, err = parseSpeedLimit(c.PostForm("download")); err != nil {
+		c.String(http.StatusBadRequest, "Invalid download limit: "+err.Error())
+		return
+	}
+	if l.Upload, err = parseSpeedLimit(c.PostForm("upload")); err != nil {
+		c.String(http.StatusBadRequest, "Invalid upload limit: "+err.Error())
+		return
+	}
+	if err := SetGlobalSpeedLimit(l); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// parseSpeedLimit reads a limit in KiB/s, empty meaning unlimited.
+func parseSpeedLimit(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func SetSpeedScheduleHandler(c *gin.Context) {
+	var rules []ScheduleRule
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := SetSpeedSchedule(rules); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func DeleteTorrentHandler(c *gin.Context) {
+	id := c.PostForm("uid")
+	if id == "" {
+		c.String(http.StatusBadRequest, "No uid provided")
+		return
+	}
+	deleteData, _ := strconv.ParseBool(c.PostForm("delete_data"))
+	removed, err := RemoveTorrent(id, deleteData)
+	if err == ErrTorrentNotFound {
+		c.String(http.StatusNotFound, "Torrent not found")
+		return
+	} else if err != nil {
+		c.JSON(http.StatusInternalServerError, removed)
+		return
+	}
+	BroadcastMessage("torrent_removed", map[string]string{"uid": id})
+	c.JSON(http.StatusOK, removed)
+}
+
 func PauseTorrentHandler(c *gin.Context) {
 	id := c.PostForm("uid")
 	if id == "" {
@@ -102,12 +777,29 @@ func ResumeTorrentHandler(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
-func DropAllHandler(c *gin.Context) {
-	if err := DropAllTorrents(); err != nil {
-		c.String(http.StatusInternalServerError, err.Error())
+func BulkHandler(c *gin.Context) {
+	var req BulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
 		return
 	}
-	c.Status(http.StatusOK)
+	results, err := ApplyBulk(req)
+	if err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+func DropAllHandler(c *gin.Context) {
+	deleteData, _ := strconv.ParseBool(c.PostForm("delete_data"))
+	removed := DropAllTorrents(deleteData)
+	for _, r := range removed {
+		if r.Status == "ok" {
+			BroadcastMessage("torrent_removed", map[string]string{"uid": r.UID})
+		}
+	}
+	c.JSON(http.StatusOK, removed)
 }
 
 func StartAllHandler(c *gin.Context) {
@@ -122,6 +814,8 @@ func StopAllHandler(c *gin.Context) {
 
 func SystemStatsHandler(c *gin.Context) {
 	Disk := DiskUsage(Root)
+	Limit := ActiveSpeedLimit(time.Now())
+	Blocklist := GetBlocklistStatus()
 	Details := SysInfo{
 		IP:        c.ClientIP(),
 		OS:        runtime.GOOS,
@@ -130,6 +824,13 @@ func SystemStatsHandler(c *gin.Context) {
 		Mem:       MemUsage(),
 		Disk:      fmt.Sprintf("%s/%s", Disk.Used, Disk.All),
 		Downloads: fmt.Sprint(GetLenTorrents()),
+		LimitDown: formatLimit(Limit.Download),
+		LimitUp:   formatLimit(Limit.Upload),
+		LimitFrom: Limit.Source,
+
+		BlocklistRanges:  fmt.Sprint(Blocklist.Ranges),
+		BlocklistUpdated: Blocklist.Updated,
+		BlocklistApplied: Blocklist.Applied,
 	}
 	c.JSON(http.StatusOK, Details)
 }
@@ -139,7 +840,7 @@ func DeleteFileHandler(c *gin.Context) {
 	if strings.Contains(path, "/downloads/downloads") {
 		path = strings.Replace(path, "/downloads", "", 1)
 	}
-	if strings.Contains(path, "torrents.db") || c.Param("path") == "/downloads/torrents" {
+	if strings.Contains(path, "torrents.db") || strings.Contains(path, "/.cloudtorrent") || c.Param("path") == "/downloads/torrents" {
 		c.String(http.StatusBadRequest, "Protected path, cant delete!")
 		return
 	}
@@ -198,7 +899,7 @@ func GetDirContentsHandler(c *gin.Context) {
 			return
 		}
 		c.JSON(http.StatusOK, files)
-	} else {
+	} else if !ServeTorrentStream(c, path) {
 		c.File(path)
 	}
 }
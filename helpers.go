@@ -4,10 +4,12 @@ import (
 	"archive/zip"
 	"bufio"
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"os"
 	"os/exec"
@@ -44,6 +46,13 @@ type SysInfo struct {
 	Mem       string `json:"mem,omitempty"`
 	Disk      string `json:"disk,omitempty"`
 	Downloads string `json:"downloads,omitempty"`
+	LimitDown string `json:"limit_down,omitempty"`
+	LimitUp   string `json:"limit_up,omitempty"`
+	LimitFrom string `json:"limit_from,omitempty"`
+	// Blocked connections are not counted by rain, only loaded ranges.
+	BlocklistRanges  string `json:"blocklist_ranges,omitempty"`
+	BlocklistUpdated string `json:"blocklist_updated,omitempty"`
+	BlocklistApplied bool   `json:"blocklist_applied"`
 }
 
 type TopTorr struct {
@@ -182,6 +191,17 @@ func StringInSlice(a string, list []string) bool {
 	return false
 }
 
+// SplitList splits a comma separated form value, dropping empty entries.
+func SplitList(s string) []string {
+	var list []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func StringToInt64(s string) int64 {
 	i, _ := strconv.ParseInt(s, 10, 64)
 	return i
@@ -198,6 +218,18 @@ func GetOutboundPort() string {
 	return ":80"
 }
 
+func readFormFile(fh *multipart.FileHeader, limit int64) ([]byte, error) {
+	if fh.Size > limit {
+		return nil, fmt.Errorf("file too large")
+	}
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(io.LimitReader(f, limit))
+}
+
 func isDirectory(path string) (bool, error) {
 	fileInfo, err := os.Stat(path)
 	if err != nil {
@@ -213,6 +245,11 @@ func GetDirContentsMap(path string) ([]FileInfo, error) {
 		return files, err
 	}
 	for i, file := range DirWalk {
+		if file.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(filepath.Join(path, file.Name())); err == nil {
+				file = target
+			}
+		}
 		var Size, Type, Ext, Icon string
 		if file.IsDir() {
 			Type = "Folder"
@@ -242,10 +279,21 @@ func AbsPath(path string) string {
 	return filepath.ToSlash(path)
 }
 
+// ServerPath returns path relative to Root, or path itself when it is
+// outside Root.
 func ServerPath(path string) string {
+	if !WithinDir(Root, filepath.Clean(path)) {
+		return AbsPath(path)
+	}
 	return strings.Replace(AbsPath(path), AbsPath(Root), "", 1)
 }
 
+// WithinDir reports whether path is dir or below it. Both must be clean.
+func WithinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
 func DirSize(path string) (int64, error) {
 	var size int64
 	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
@@ -268,6 +316,35 @@ func PrepareWD() {
 	}
 }
 
+// loadState reads a JSON state file kept next to torrents.db into v. A
+// missing file leaves v untouched.
+func loadState(name string, v interface{}) error {
+	data, err := ioutil.ReadFile(filepath.Join(StateDir, name))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// saveState writes v to a JSON state file, replacing the old file
+// atomically so a crash never leaves a truncated file behind.
+func saveState(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(StateDir, 0755); err != nil {
+		return err
+	}
+	tmp := filepath.Join(StateDir, name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(StateDir, name))
+}
+
 func GetPath(path string, file os.FileInfo) string {
 	if file.IsDir() {
 		return "/downloads" + ServerPath(path+"/"+file.Name())
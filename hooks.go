@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const hooksStateFile = "hooks.json"
+
+// hooksConfigFile lists the hooks. It is only read, and lives next to the
+// program rather than below Root, so hook commands can not be set through
+// the API or by uploading files.
+var hooksConfigFile = filepath.Join(Wd, "hooks.json")
+
+const (
+	hookHistorySize    = 200
+	hookOutputLimit    = 64 << 10
+	defaultHookTimeout = 300
+	// hookWaitDelay is how long a killed hook's output may stay open.
+	hookWaitDelay = 5 * time.Second
+)
+
+// Events hooks and webhooks can subscribe to.
+var hookEvents = []string{
+	"torrent_added", "torrent_complete", "torrent_error",
+	"aria2_complete", "aria2_error",
+	"ffmpeg_complete", "ffmpeg_error",
+}
+
+// Hook runs Command with sh -c when Event happens. Torrent hooks with a
+// Category only run for torrents in it.
+type Hook struct {
+	Name     string `json:"name"`
+	Event    string `json:"event"`
+	Command  string `json:"command"`
+	Category string `json:"category,omitempty"`
+	Timeout  int    `json:"timeout"`
+	Disabled bool   `json:"disabled,omitempty"`
+}
+
+// HookEvent describes a lifecycle event. It is passed to hooks as CT_*
+// environment variables.
+type HookEvent struct {
+	Event    string `json:"event"`
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Hash     string `json:"hash,omitempty"`
+	Size     int64  `json:"size"`
+	Category string `json:"category,omitempty"`
+}
+
+type HookRun struct {
+	Hook      string    `json:"hook"`
+	HookEvent HookEvent `json:"event"`
+	StartedAt time.Time `json:"started_at"`
+	Seconds   float64   `json:"seconds"`
+	ExitCode  int       `json:"exit_code"`
+	Stdout    string    `json:"stdout"`
+	Stderr    string    `json:"stderr"`
+	Error     string    `json:"error,omitempty"`
+}
+
+var (
+	// Seen is the last seen state of everything hooks watch, keyed by
+	// kind and ID. It is saved so what finishes while the program is
+	// down still fires once it is back.
+	hooksState = struct {
+		History []HookRun         `json:"history"`
+		Seen    map[string]string `json:"seen"`
+	}{}
+	hooks        []Hook
+	hooksModTime time.Time
+	hooksMutex   sync.Mutex
+)
+
+func InitHooks() {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	if err := loadState(hooksStateFile, &hooksState); err != nil {
+		log.Printf("Could not load hooks: %v", err)
+	}
+	if hooksState.Seen == nil {
+		hooksState.Seen = make(map[string]string)
+	}
+	loadHooksConfig()
+}
+
+func saveHooksState() {
+	if err := saveState(hooksStateFile, &hooksState); err != nil {
+		log.Printf("Could not save hooks: %v", err)
+	}
+}
+
+// loadHooksConfig reads hooksConfigFile again when it changed. Invalid
+// hooks are skipped. It must be called with hooksMutex held.
+func loadHooksConfig() {
+	fi, err := os.Stat(hooksConfigFile)
+	if os.IsNotExist(err) {
+		hooks, hooksModTime = nil, time.Time{}
+		return
+	} else if err != nil || fi.ModTime().Equal(hooksModTime) {
+		return
+	}
+	hooksModTime = fi.ModTime()
+	var config struct {
+		Hooks []Hook `json:"hooks"`
+	}
+	data, err := ioutil.ReadFile(hooksConfigFile)
+	if err == nil {
+		err = json.Unmarshal(data, &config)
+	}
+	if err != nil {
+		log.Printf("Could not load %s: %v", hooksConfigFile, err)
+		return
+	}
+	hooks = nil
+	for _, h := range config.Hooks {
+		if err := h.validate(); err != nil {
+			log.Printf("Skipping hook %q: %v", h.Name, err)
+			continue
+		}
+		hooks = append(hooks, h)
+	}
+}
+
+func (h *Hook) validate() error {
+	if h.Command == "" {
+		return fmt.Errorf("hook command is required")
+	}
+	if !StringInSlice(h.Event, hookEvents) {
+		return fmt.Errorf("invalid event %q", h.Event)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("timeout must not be negative")
+	}
+	if h.Timeout == 0 {
+		h.Timeout = defaultHookTimeout
+	}
+	if h.Name == "" {
+		h.Name = h.Event
+	}
+	return nil
+}
+
+func GetHooks() []Hook {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	loadHooksConfig()
+	return append([]Hook{}, hooks...)
+}
+
+// GetHookHistory returns the most recent hook runs first.
+func GetHookHistory() []HookRun {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	list := make([]HookRun, 0, len(hooksState.History))
+	for i := len(hooksState.History) - 1; i >= 0; i-- {
+		list = append(list, hooksState.History[i])
+	}
+	return list
+}
+
+// detectHookEvents compares the state of torrents, aria2 downloads and
+// ffmpeg jobs with the previous call and fires events for the ones that
+// finished or failed in between. Whatever is already finished the first
+// time it is seen does not fire.
+func detectHookEvents() {
+	seen := make(map[string]bool)
+	for _, t := range GetTorrents() {
+		stats := t.Stats()
+		state := "running"
+		if stats.Error != nil {
+			state = "error"
+		} else if stats.Pieces.Total > 0 && stats.Pieces.Have == stats.Pieces.Total {
+			state = "complete"
+			m := GetMeta(t.ID())
+			// Wait for the library action so hooks see the final path.
+			if c, ok := GetCategory(m.Category); ok && c.Library != "" && m.Library == "" {
+				state = "running"
+			}
+		}
+		key := "torrent:" + t.ID()
+		seen[key] = true
+		if hookTransition(key, state) {
+			emitEvent(HookEvent{
+				Event:    "torrent_" + state,
+				Name:     t.Name(),
+				Path:     filepath.Join(TorrentDataDir(t.ID()), t.Name()),
+				Hash:     t.InfoHash().String(),
+				Size:     stats.Bytes.Total,
+				Category: GetMeta(t.ID()).Category,
+			})
+		}
+	}
+	if IsAria2Available() {
+		for _, d := range GetAria2Downloads() {
+			state := map[string]string{"Completed": "complete", "Error": "error"}[d.Status]
+			key := "aria2:" + d.GID
+			seen[key] = true
+			if hookTransition(key, state) {
+				emitEvent(HookEvent{Event: "aria2_" + state, Name: d.Name, Path: d.Path, Size: d.TotalLength})
+			}
+		}
+	}
+	if IsFFmpegAvailable() {
+		for _, job := range GetConversionQueue() {
+			state := map[string]string{"completed": "complete", "error": "error"}[job.Status]
+			key := "ffmpeg:" + job.ID
+			seen[key] = true
+			if hookTransition(key, state) {
+				var size int64
+				if fi, err := os.Stat(job.OutputPath); err == nil {
+					size = fi.Size()
+				}
+				emitEvent(HookEvent{Event: "ffmpeg_" + state, Name: job.OutputName, Path: job.OutputPath, Size: size})
+			}
+		}
+	}
+	hooksMutex.Lock()
+	changed := false
+	for key := range hooksState.Seen {
+		if !seen[key] {
+			delete(hooksState.Seen, key)
+			changed = true
+		}
+	}
+	if changed {
+		saveHooksState()
+	}
+	hooksMutex.Unlock()
+}
+
+// hookTransition records the state of key and reports whether it just
+// became complete or error.
+func hookTransition(key, state string) bool {
+	hooksMutex.Lock()
+	defer hooksMutex.Unlock()
+	prev, known := hooksState.Seen[key]
+	if known && prev == state {
+		return false
+	}
+	hooksState.Seen[key] = state
+	saveHooksState()
+	return known && (state == "complete" || state == "error")
+}
+
+// emitEvent runs the hooks and notifies the webhooks subscribed to an
+// event.
+func emitEvent(ev HookEvent) {
+	fireHooks(ev)
+	fireWebhooks(ev)
+}
+
+func fireHooks(ev HookEvent) {
+	for _, h := range GetHooks() {
+		if h.Disabled || h.Event != ev.Event || (h.Category != "" && h.Category != ev.Category) {
+			continue
+		}
+		go runHook(h, ev)
+	}
+}
+
+func runHook(h Hook, ev HookEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(h.Timeout)*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, "sh", "-c", h.Command)
+	cmd.Dir = Root
+	// Run the hook in its own process group, so a timeout also kills
+	// what the shell started, and stop waiting for output held open by
+	// anything that escaped it.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = hookWaitDelay
+	cmd.Env = append(os.Environ(),
+		"CT_EVENT="+ev.Event,
+		"CT_NAME="+ev.Name,
+		"CT_PATH="+ev.Path,
+		"CT_HASH="+ev.Hash,
+		"CT_SIZE="+strconv.FormatInt(ev.Size, 10),
+		"CT_CATEGORY="+ev.Category,
+	)
+	stdout := &limitedBuffer{limit: hookOutputLimit}
+	stderr := &limitedBuffer{limit: hookOutputLimit}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+
+	run := HookRun{Hook: h.Name, HookEvent: ev, StartedAt: time.Now()}
+	err := cmd.Run()
+	run.Seconds = time.Since(run.StartedAt).Seconds()
+	run.Stdout, run.Stderr = stdout.String(), stderr.String()
+	if cmd.ProcessState != nil {
+		run.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		run.Error = fmt.Sprintf("timed out after %ds", h.Timeout)
+	} else if err != nil {
+		run.Error = err.Error()
+	}
+	if run.Error != "" {
+		log.Printf("Hook %s failed for %s: %s", h.Name, ev.Name, run.Error)
+	}
+
+	hooksMutex.Lock()
+	hooksState.History = append(hooksState.History, run)
+	if len(hooksState.History) > hookHistorySize {
+		hooksState.History = hooksState.History[len(hooksState.History)-hookHistorySize:]
+	}
+	saveHooksState()
+	hooksMutex.Unlock()
+	BroadcastMessage("hook_run", run)
+}
+
+// limitedBuffer keeps the first limit bytes written to it and discards
+// the rest.
+type limitedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.Len(); room > 0 {
+		if len(p) > room {
+			b.Buffer.Write(p[:room])
+		} else {
+			b.Buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
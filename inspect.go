@@ -0,0 +1,296 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+var ErrInspectTimeout = fmt.Errorf("timed out waiting for metadata")
+
+const (
+	inspectTimeout    = 60 * time.Second
+	inspectMaxTimeout = 5 * time.Minute
+	// Fetched metadata is kept this long for a follow-up add.
+	inspectCacheTTL = time.Hour
+	// The inspect session is closed after being unused this long.
+	inspectSessionIdle = 5 * time.Minute
+	// Ports of the inspect session, SessionSettings.Validate keeps the
+	// main session out of them.
+	inspectPortBegin = 39500
+	inspectPortEnd   = 39599
+	inspectDHTPort   = 39600
+)
+
+// MagnetInfo is what a magnet link resolves to.
+type MagnetInfo struct {
+	InfoHash string      `json:"info_hash"`
+	Name     string      `json:"name"`
+	Size     int64       `json:"size"`
+	Private  bool        `json:"private"`
+	Files    []MetaFile  `json:"files"`
+	Tree     []*FileNode `json:"tree"`
+}
+
+// FileNode is a file or directory of a torrent's file tree. Index is the
+// position of a file in Files, -1 for directories.
+type FileNode struct {
+	Name     string      `json:"name"`
+	Path     string      `json:"path"`
+	Size     int64       `json:"size"`
+	Index    int         `json:"index"`
+	Children []*FileNode `json:"children,omitempty"`
+}
+
+type inspected struct {
+	data    []byte
+	fetched time.Time
+}
+
+type inspection struct {
+	done chan struct{}
+	data []byte
+	err  error
+}
+
+var (
+	inspectCache   = make(map[string]*inspected)
+	inspectRunning = make(map[string]*inspection)
+	inspectMutex   sync.Mutex
+
+	// Magnets are inspected in a session of their own, whose database and
+	// data live in a temporary directory, so they are never persisted
+	// with the torrents of the main session.
+	inspectSession      *torrent.Session
+	inspectDir          string
+	inspectUsers        int
+	inspectIdle         *time.Timer
+	inspectSessionMutex sync.Mutex
+)
+
+// InspectMagnet resolves the metadata of a magnet link through DHT and
+// its trackers, waiting at most timeout, without adding the torrent.
+func InspectMagnet(magnet string, timeout time.Duration) (*MagnetInfo, error) {
+	magnet = strings.TrimSpace(magnet)
+	hash := ParseHashFromMagnet(magnet)
+	if !strings.HasPrefix(magnet, "magnet:") || hash == "" {
+		return nil, fmt.Errorf("invalid magnet link")
+	}
+	if timeout <= 0 {
+		timeout = inspectTimeout
+	} else if timeout > inspectMaxTimeout {
+		timeout = inspectMaxTimeout
+	}
+	if t := FindTorrentByHash(hash); t != nil {
+		data, err := t.Torrent()
+		if err != nil || t.Stats().Status == torrent.DownloadingMetadata {
+			return nil, fmt.Errorf("torrent already added, metadata not available yet")
+		}
+		return newMagnetInfo(data)
+	}
+	if data := inspectedTorrent(hash); data != nil {
+		return newMagnetInfo(data)
+	}
+
+	// Requests for the same magnet share one lookup.
+	inspectMutex.Lock()
+	in, running := inspectRunning[hash]
+	if !running {
+		in = &inspection{done: make(chan struct{})}
+		inspectRunning[hash] = in
+	}
+	inspectMutex.Unlock()
+	if !running {
+		in.data, in.err = fetchMagnetMetadata(magnet, timeout)
+		inspectMutex.Lock()
+		delete(inspectRunning, hash)
+		if in.err == nil {
+			inspectCache[hash] = &inspected{data: in.data, fetched: time.Now()}
+		}
+		inspectMutex.Unlock()
+		close(in.done)
+	}
+	select {
+	case <-in.done:
+	case <-time.After(timeout):
+		return nil, ErrInspectTimeout
+	}
+	if in.err != nil {
+		return nil, in.err
+	}
+	return newMagnetInfo(in.data)
+}
+
+func fetchMagnetMetadata(magnet string, timeout time.Duration) ([]byte, error) {
+	s, err := acquireInspectSession()
+	if err != nil {
+		return nil, err
+	}
+	defer releaseInspectSession()
+	t, err := s.AddURI(magnet, &torrent.AddTorrentOptions{StopAfterMetadata: true})
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := s.RemoveTorrent(t.ID()); err != nil {
+			log.Printf("Could not remove inspected torrent %s: %v", t.ID(), err)
+		}
+	}()
+	if !strings.Contains(magnet, "tr=") {
+		addTrackers(t)
+	}
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		stats := t.Stats()
+		if stats.Error != nil {
+			return nil, stats.Error
+		}
+		if stats.Status != torrent.DownloadingMetadata && stats.Name != "" {
+			return t.Torrent()
+		}
+		time.Sleep(250 * time.Millisecond)
+	}
+	return nil, ErrInspectTimeout
+}
+
+// acquireInspectSession opens the inspect session if it is not open yet.
+// Every call must be followed by releaseInspectSession.
+func acquireInspectSession() (*torrent.Session, error) {
+	inspectSessionMutex.Lock()
+	defer inspectSessionMutex.Unlock()
+	if inspectIdle != nil {
+		inspectIdle.Stop()
+		inspectIdle = nil
+	}
+	if inspectSession == nil {
+		dir, err := ioutil.TempDir("", "cloudtorrent-inspect")
+		if err != nil {
+			return nil, err
+		}
+		config := newClientConfig()
+		config.Database = filepath.Join(dir, "inspect.db")
+		config.DataDir = dir
+		config.PortBegin, config.PortEnd = inspectPortBegin, inspectPortEnd
+		config.DHTPort = inspectDHTPort
+		config.RPCEnabled = false
+		config.ResumeOnStartup = false
+		s, err := torrent.NewSession(config)
+		if err != nil {
+			os.RemoveAll(dir)
+			return nil, err
+		}
+		inspectSession, inspectDir = s, dir
+	}
+	inspectUsers++
+	return inspectSession, nil
+}
+
+func releaseInspectSession() {
+	inspectSessionMutex.Lock()
+	defer inspectSessionMutex.Unlock()
+	if inspectUsers--; inspectUsers == 0 {
+		inspectIdle = time.AfterFunc(inspectSessionIdle, closeInspectSession)
+	}
+}
+
+func closeInspectSession() {
+	inspectSessionMutex.Lock()
+	defer inspectSessionMutex.Unlock()
+	if inspectUsers > 0 || inspectSession == nil {
+		return
+	}
+	if err := inspectSession.Close(); err != nil {
+		log.Printf("Error closing inspect session: %v", err)
+	}
+	os.RemoveAll(inspectDir)
+	inspectSession, inspectDir = nil, ""
+}
+
+// inspectedTorrent returns the .torrent fetched for an info hash by a
+// recent inspection.
+func inspectedTorrent(hash string) []byte {
+	inspectMutex.Lock()
+	defer inspectMutex.Unlock()
+	for h, in := range inspectCache {
+		if time.Since(in.fetched) > inspectCacheTTL {
+			delete(inspectCache, h)
+		}
+	}
+	if in, ok := inspectCache[strings.ToLower(hash)]; ok {
+		return in.data
+	}
+	return nil
+}
+
+func forgetInspected(hash string) {
+	inspectMutex.Lock()
+	delete(inspectCache, strings.ToLower(hash))
+	inspectMutex.Unlock()
+}
+
+func newMagnetInfo(data []byte) (*MagnetInfo, error) {
+	meta, err := ParseMetainfo(data)
+	if err != nil {
+		return nil, err
+	}
+	return &MagnetInfo{
+		InfoHash: meta.InfoHash,
+		Name:     meta.Name,
+		Size:     meta.Length,
+		Private:  meta.Private,
+		Files:    meta.Files,
+		Tree:     fileTree(meta.Files),
+	}, nil
+}
+
+// fileTree nests the files by directory, directories first and sorted by
+// name, with directory sizes summed up.
+func fileTree(files []MetaFile) []*FileNode {
+	root := &FileNode{Index: -1}
+	// path.Dir ends at "." for relative paths and "/" for absolute ones
+	dirs := map[string]*FileNode{".": root, "/": root}
+	var dir func(p string) *FileNode
+	dir = func(p string) *FileNode {
+		if n, ok := dirs[p]; ok {
+			return n
+		}
+		parent := dir(path.Dir(p))
+		n := &FileNode{Name: path.Base(p), Path: p, Index: -1}
+		parent.Children = append(parent.Children, n)
+		dirs[p] = n
+		return n
+	}
+	for i, f := range files {
+		parent := dir(path.Dir(f.Path))
+		parent.Children = append(parent.Children, &FileNode{Name: path.Base(f.Path), Path: f.Path, Size: f.Length, Index: i})
+	}
+	var finish func(n *FileNode) int64
+	finish = func(n *FileNode) int64 {
+		if n.Index >= 0 {
+			return n.Size
+		}
+		n.Size = 0
+		for _, c := range n.Children {
+			n.Size += finish(c)
+		}
+		sort.SliceStable(n.Children, func(i, j int) bool {
+			a, b := n.Children[i], n.Children[j]
+			if (a.Index < 0) != (b.Index < 0) {
+				return a.Index < 0
+			}
+			return a.Name < b.Name
+		})
+		return n.Size
+	}
+	finish(root)
+	return root.Children
+}
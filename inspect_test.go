@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestFileTree(t *testing.T) {
+	tree := fileTree([]MetaFile{
+		{Path: "dir/b.txt", Length: 2},
+		{Path: "dir/sub/a.txt", Length: 3},
+		{Path: "dir/a.txt", Length: 1},
+	})
+	if len(tree) != 1 || tree[0].Name != "dir" || tree[0].Size != 6 {
+		t.Fatalf("unexpected root %+v", tree)
+	}
+	var names []string
+	for _, c := range tree[0].Children {
+		names = append(names, c.Name)
+	}
+	// directories first, then files by name
+	if len(names) != 3 || names[0] != "sub" || names[1] != "a.txt" || names[2] != "b.txt" {
+		t.Errorf("children %v", names)
+	}
+}
+
+func TestFileTreeAbsolutePaths(t *testing.T) {
+	tree := fileTree([]MetaFile{{Path: "/etc/passwd", Length: 1}, {Path: "/x", Length: 1}})
+	if len(tree) != 2 {
+		t.Fatalf("unexpected tree %+v", tree)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+// Library modes of a category. Moved torrents keep seeding from the
+// library, hardlinked ones from where they were downloaded.
+const (
+	LibraryMove     = "move"
+	LibraryHardlink = "hardlink"
+)
+
+func validLibrary(dir, mode string) error {
+	if dir == "" {
+		return nil
+	}
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("library directory must be an absolute path")
+	}
+	if mode != LibraryMove && mode != LibraryHardlink {
+		return fmt.Errorf("invalid library mode %q", mode)
+	}
+	return nil
+}
+
+// applyLibraryActions hands every completed torrent of a category with a
+// library to the library once.
+func applyLibraryActions() {
+	for _, t := range GetTorrents() {
+		p := t.Stats().Pieces
+		if p.Total == 0 || p.Have != p.Total {
+			continue
+		}
+		m := GetMeta(t.ID())
+		if m.Library != "" || m.Category == "" {
+			continue
+		}
+		c, ok := GetCategory(m.Category)
+		if !ok || c.Library == "" {
+			continue
+		}
+		if !markDataBusy(t.ID()) {
+			continue
+		}
+		go func(t *torrent.Torrent, c Category) {
+			defer unmarkDataBusy(t.ID())
+			target, err := sendToLibrary(t, c.Library, c.LibraryMode)
+			if err != nil {
+				log.Printf("Could not %s %s to library: %v", c.LibraryMode, t.Name(), err)
+				target = "failed: " + err.Error()
+			}
+			setMetaLibrary(t.ID(), target)
+		}(t, c)
+	}
+}
+
+// sendToLibrary hands the data of a torrent to a library. Hardlinked data
+// appears as lib/<name>. Moved data goes to lib/<id>/<name> and rain's data
+// directory for the torrent becomes a symlink to lib/<id>, as for
+// categories. The data only appears under its final name once it is
+// complete.
+func sendToLibrary(t *torrent.Torrent, lib, mode string) (string, error) {
+	current := TorrentDataDir(t.ID())
+	src := filepath.Join(current, t.Name())
+	if mode == LibraryHardlink {
+		dst := filepath.Join(lib, t.Name())
+		if _, err := os.Lstat(dst); err == nil {
+			return "", fmt.Errorf("%s already exists", dst)
+		}
+		if err := os.MkdirAll(lib, 0755); err != nil {
+			return "", err
+		}
+		return dst, linkTree(src, dst)
+	}
+	mv := libraryMove{From: current, To: filepath.Join(lib, t.ID()), Name: t.Name()}
+	if _, err := os.Lstat(mv.To); err == nil {
+		return "", fmt.Errorf("%s already exists", mv.To)
+	}
+	running, err := stopAndWait(t)
+	if err != nil {
+		return "", err
+	}
+	if running {
+		defer resumeAfterMove(t.ID())
+	}
+	if err := os.MkdirAll(lib, 0755); err != nil {
+		return "", err
+	}
+	if err := journalLibraryMove(t.ID(), &mv); err != nil {
+		return "", err
+	}
+	partial := mv.To + ".partial"
+	if err := os.MkdirAll(partial, 0755); err != nil {
+		return "", err
+	}
+	if err := moveDir(src, filepath.Join(partial, mv.Name)); err != nil {
+		if _, serr := os.Stat(filepath.Join(partial, mv.Name)); serr != nil {
+			os.RemoveAll(partial)
+			journalLibraryMove(t.ID(), nil)
+			return "", err
+		}
+		log.Printf("Could not remove the old data of %s: %v", t.Name(), err)
+	}
+	if err := mv.finish(t.ID()); err != nil {
+		return "", err
+	}
+	journalLibraryMove(t.ID(), nil)
+	return filepath.Join(mv.To, mv.Name), nil
+}
+
+const libraryMovesFile = "library_moves.json"
+
+// libraryMove is a move into a library that has started but whose symlink
+// may not be in place yet. Moves are journaled so one interrupted by a
+// crash is finished, or undone, on the next start.
+type libraryMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Name string `json:"name"`
+}
+
+var (
+	libraryMoves      = make(map[string]libraryMove)
+	libraryMovesMutex sync.Mutex
+)
+
+// journalLibraryMove records a move of a torrent, or forgets it when mv is
+// nil.
+func journalLibraryMove(id string, mv *libraryMove) error {
+	libraryMovesMutex.Lock()
+	defer libraryMovesMutex.Unlock()
+	if mv != nil {
+		libraryMoves[id] = *mv
+	} else {
+		delete(libraryMoves, id)
+	}
+	return saveState(libraryMovesFile, libraryMoves)
+}
+
+// finish renames the moved data, complete below To.partial, to To and
+// points rain's data directory for the torrent at it. The old data
+// directory is removed if it only held the moved data.
+func (mv libraryMove) finish(id string) error {
+	partial := mv.To + ".partial"
+	if _, err := os.Stat(filepath.Join(partial, mv.Name)); err == nil {
+		if err := os.Rename(partial, mv.To); err != nil {
+			return err
+		}
+	}
+	link := filepath.Join(Root, "torrents", id)
+	if target, err := os.Readlink(link); err == nil && target == mv.To {
+		return nil
+	}
+	if filepath.Base(mv.From) == id {
+		if err := os.RemoveAll(mv.From); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(mv.To, link)
+}
+
+// repairLibraryMoves deals with library moves interrupted by a crash. A
+// move whose data was completely moved or copied is finished; any other is
+// undone and tried again once the session is up. It runs before the
+// session is opened so rain never sees a torrent without its data.
+func repairLibraryMoves() {
+	libraryMovesMutex.Lock()
+	if err := loadState(libraryMovesFile, &libraryMoves); err != nil {
+		log.Printf("Could not load library moves: %v", err)
+	}
+	moves := make(map[string]libraryMove, len(libraryMoves))
+	for id, mv := range libraryMoves {
+		moves[id] = mv
+	}
+	libraryMovesMutex.Unlock()
+	for id, mv := range moves {
+		_, errPartial := os.Stat(filepath.Join(mv.To+".partial", mv.Name))
+		_, errMoved := os.Stat(mv.To)
+		if errPartial == nil || errMoved == nil {
+			// A copy may have stopped while removing its source.
+			os.RemoveAll(filepath.Join(mv.From, mv.Name))
+			if err := mv.finish(id); err != nil {
+				log.Printf("Could not finish moving %s to %s: %v", id, mv.To, err)
+				continue
+			}
+			setMetaLibrary(id, filepath.Join(mv.To, mv.Name))
+		} else {
+			os.RemoveAll(mv.To + ".partial")
+		}
+		journalLibraryMove(id, nil)
+	}
+}
+
+// linkTree hardlinks src into dst, copying files that can not be linked,
+// for example across devices.
+func linkTree(src, dst string) error {
+	tmp := dst + ".partial"
+	os.RemoveAll(tmp)
+	err := filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(src, path)
+		out := filepath.Join(tmp, rel)
+		if info.IsDir() {
+			return os.MkdirAll(out, info.Mode().Perm())
+		}
+		if err := os.Link(path, out); err == nil {
+			return nil
+		}
+		return copyFile(path, out, info.Mode().Perm())
+	})
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+	}
+	return err
+}
@@ -11,18 +11,34 @@ import (
 )
 
 var (
-	Wd, _ = os.Getwd()
-	Root  = filepath.Join(Wd, "downloads")
-	Port  = GetOutboundPort()
+	Wd, _    = os.Getwd()
+	Root     = filepath.Join(Wd, "downloads")
+	StateDir = filepath.Join(Root, ".cloudtorrent")
+	Port     = GetOutboundPort()
 )
 
 func main() {
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
 
+	// Load saved state and start the torrent session
+	InitBandwidth()
+	InitCategories()
+	InitHooks()
+	InitMeta()
+	InitQueue()
+	InitSeeding()
+	InitTorrents()
+	InitWebhooks()
+
 	// Initialize WebSocket
 	InitWebSocket()
 
+	// Start the torrent supervisor
+	InitSupervisor()
+	InitWatch()
+	InitFeeds()
+
 	// Static files
 	r.Static("/static", "./static")
 
@@ -46,6 +62,33 @@ func main() {
 		tmpl.Execute(c.Writer, nil)
 	})
 
+	// Transmission RPC for clients such as Sonarr and transmission-remote
+	r.GET("/transmission/rpc", TransmissionRPCHandler)
+	r.POST("/transmission/rpc", TransmissionRPCHandler)
+
+	// qBittorrent WebUI API for clients such as autobrr and cross-seed
+	qbit := r.Group("/api/v2")
+	{
+		qbit.POST("/auth/login", QbitLoginHandler)
+		qbit.POST("/auth/logout", QbitLogoutHandler)
+		qbit.GET("/app/version", QbitVersionHandler)
+		qbit.GET("/app/webapiVersion", QbitWebAPIVersionHandler)
+		qbit.GET("/app/preferences", QbitPreferencesHandler)
+		qbit.GET("/torrents/info", QbitTorrentsInfoHandler)
+		qbit.POST("/torrents/add", QbitTorrentsAddHandler)
+		qbit.POST("/torrents/pause", QbitPauseHandler)
+		qbit.POST("/torrents/stop", QbitPauseHandler)
+		qbit.POST("/torrents/resume", QbitResumeHandler)
+		qbit.POST("/torrents/start", QbitResumeHandler)
+		qbit.POST("/torrents/delete", QbitDeleteHandler)
+		qbit.GET("/torrents/files", QbitFilesHandler)
+		qbit.GET("/torrents/categories", QbitCategoriesHandler)
+		qbit.POST("/torrents/createCategory", QbitSetCategoryHandler)
+		qbit.POST("/torrents/editCategory", QbitSetCategoryHandler)
+		qbit.POST("/torrents/removeCategories", QbitRemoveCategoriesHandler)
+		qbit.POST("/torrents/setCategory", QbitSetTorrentCategoryHandler)
+	}
+
 	// WebSocket endpoint
 	r.GET("/ws", func(c *gin.Context) {
 		WSHandler(c.Writer, c.Request)
@@ -56,12 +99,59 @@ func main() {
 	{
 		// Torrent APIs
 		api.POST("/add", AddTorrentHandler)
+		api.POST("/magnet/inspect", InspectMagnetHandler)
+		api.GET("/export", ExportHandler)
+		api.POST("/import", ImportHandler)
 		api.GET("/torrents", ActiveTorrentsHandler)
 		api.GET("/torrent", GetTorrentHandler)
+		api.POST("/torrent/meta", SetTorrentMetaHandler)
+		api.POST("/torrent/category", SetTorrentCategoryHandler)
+		api.POST("/torrent/streaming", SetStreamingHandler)
+		api.GET("/torrent/peers", GetTorrentPeersHandler)
+		api.POST("/torrent/create", CreateTorrentHandler)
+		api.GET("/torrent/create", GetCreateJobHandler)
+		api.GET("/torrent/pieces", GetPieceMapHandler)
+		api.POST("/torrent/recheck", RecheckHandler)
+		api.GET("/torrent/trackers", GetTorrentTrackersHandler)
+		api.POST("/torrent/trackers/add", AddTorrentTrackerHandler)
+		api.POST("/torrent/reannounce", ReannounceHandler)
+		api.GET("/torrent/files", GetTorrentFilesHandler)
+		api.GET("/categories", GetCategoriesHandler)
+		api.POST("/categories", SetCategoryHandler)
+		api.POST("/categories/remove", RemoveCategoryHandler)
+		api.GET("/trackers", GetTrackerSourceHandler)
+		api.POST("/trackers", SetTrackerSourceHandler)
+		api.GET("/feeds", GetFeedsHandler)
+		api.POST("/feeds", SetFeedHandler)
+		api.POST("/feeds/remove", RemoveFeedHandler)
+		api.POST("/feeds/test", TestFeedRuleHandler)
+		api.GET("/hooks", GetHooksHandler)
+		api.GET("/hooks/history", GetHookHistoryHandler)
+		api.GET("/webhooks", GetWebhooksHandler)
+		api.POST("/webhooks", SetWebhookHandler)
+		api.POST("/webhooks/remove", RemoveWebhookHandler)
+		api.POST("/webhooks/test", TestWebhookHandler)
+		api.GET("/webhooks/deliveries", GetWebhookDeliveriesHandler)
+		api.GET("/watch", GetWatchHandler)
+		api.POST("/watch", SetWatchHandler)
+		api.GET("/settings", GetSettingsHandler)
+		api.POST("/settings", SetSettingsHandler)
+		api.GET("/blocklist", GetBlocklistHandler)
+		api.POST("/blocklist", SetBlocklistHandler)
+		api.POST("/blocklist/reload", ReloadBlocklistHandler)
+		api.GET("/queue", GetQueueHandler)
+		api.POST("/queue", SetQueueHandler)
+		api.POST("/queue/move", MoveInQueueHandler)
+		api.GET("/seeding", GetSeedPolicyHandler)
+		api.POST("/seeding", SetSeedPolicyHandler)
+		api.GET("/limits", GetSpeedLimitsHandler)
+		api.POST("/limits", SetSpeedLimitHandler)
+		api.POST("/limits/schedule", SetSpeedScheduleHandler)
 		api.POST("/remove", DeleteTorrentHandler)
 		api.POST("/pause", PauseTorrentHandler)
 		api.POST("/resume", ResumeTorrentHandler)
 		api.POST("/removeall", DropAllHandler)
+		api.POST("/bulk", BulkHandler)
 		api.POST("/stopall", StopAllHandler)
 		api.POST("/startall", StartAllHandler)
 
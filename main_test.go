@@ -0,0 +1,22 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain points the download and state directories at a temporary
+// directory, so tests never touch real downloads. No session is started.
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "cloudtorrent-test")
+	if err != nil {
+		panic(err)
+	}
+	Root = filepath.Join(dir, "downloads")
+	StateDir = filepath.Join(Root, ".cloudtorrent")
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const metaStateFile = "meta.json"
+
+// TorrentMeta is what we know about a torrent beyond what rain reports.
+// Seq is the stable number shown as TorrentData.ID.
+type TorrentMeta struct {
+	Seq         int       `json:"seq"`
+	AddedAt     time.Time `json:"added_at"`
+	CompletedAt time.Time `json:"completed_at,omitempty"`
+	Source      string    `json:"source,omitempty"`
+	Category    string    `json:"category,omitempty"`
+	Label       string    `json:"label,omitempty"`
+	Notes       string    `json:"notes,omitempty"`
+	Streaming   bool      `json:"streaming,omitempty"`
+	Library     string    `json:"library,omitempty"`
+}
+
+var (
+	metaState = struct {
+		NextSeq  int                     `json:"next_seq"`
+		Torrents map[string]*TorrentMeta `json:"torrents"`
+	}{
+		NextSeq:  1,
+		Torrents: make(map[string]*TorrentMeta),
+	}
+	metaMutex sync.RWMutex
+)
+
+func InitMeta() {
+	metaMutex.Lock()
+	defer metaMutex.Unlock()
+	if err := loadState(metaStateFile, &metaState); err != nil {
+		log.Printf("Could not load torrent metadata: %v", err)
+	}
+	if metaState.Torrents == nil {
+		metaState.Torrents = make(map[string]*TorrentMeta)
+	}
+	if metaState.NextSeq < 1 {
+		metaState.NextSeq = 1
+	}
+}
+
+func saveMetaState() {
+	if err := saveState(metaStateFile, &metaState); err != nil {
+		log.Printf("Could not save torrent metadata: %v", err)
+	}
+}
+
+// syncMeta creates entries for torrents the store does not know about yet,
+// such as ones added before it existed, numbering them in the order rain
+// added them.
+func syncMeta() {
+	torrents := GetTorrents()
+	sort.Slice(torrents, func(i, j int) bool {
+		return torrents[i].AddedAt().Before(torrents[j].AddedAt())
+	})
+	metaMutex.Lock()
+	defer metaMutex.Unlock()
+	changed := false
+	for _, t := range torrents {
+		if _, ok := metaState.Torrents[t.ID()]; !ok {
+			metaState.Torrents[t.ID()] = &TorrentMeta{Seq: metaState.NextSeq, AddedAt: t.AddedAt()}
+			metaState.NextSeq++
+			changed = true
+		}
+	}
+	if changed {
+		saveMetaState()
+	}
+}
+
+func newMeta(id, source string, opts *AddOptions) {
+	metaMutex.Lock()
+	defer metaMutex.Unlock()
+	m := &TorrentMeta{Seq: metaState.NextSeq, AddedAt: time.Now(), Source: source}
+	if opts != nil {
+		m.Label = opts.Label
+		m.Notes = opts.Notes
+	}
+	metaState.NextSeq++
+	metaState.Torrents[id] = m
+	saveMetaState()
+}
+
+// GetMeta returns a copy of the metadata of a torrent.
+func GetMeta(id string) TorrentMeta {
+	metaMutex.RLock()
+	defer metaMutex.RUnlock()
+	if m, ok := metaState.Torrents[id]; ok {
+		return *m
+	}
+	return TorrentMeta{}
+}
+
+// SetMetaInfo updates the label and notes of a torrent, a nil value keeps
+// the current one.
+func SetMetaInfo(id string, label, notes *string) error {
+	if GetSession().GetTorrent(id) == nil {
+		return ErrTorrentNotFound
+	}
+	syncMeta()
+	metaMutex.Lock()
+	defer metaMutex.Unlock()
+	m := metaState.Torrents[id]
+	if label != nil {
+		m.Label = *label
+	}
+	if notes != nil {
+		m.Notes = *notes
+	}
+	saveMetaState()
+	return nil
+}
+
+func setMetaCategory(id, category string) {
+	syncMeta()
+	metaMutex.Lock()
+	defer metaMutex.Unlock()
+	if m, ok := metaState.Torrents[id]; ok {
+		m.Category = category
+		saveMetaState()
+	}
+}
+
+func setMetaStreaming(id string, enabled bool) {
+	syncMeta()
+	metaMutex.Lock()
+	defer metaMutex.Unlock()
+	if m, ok := metaState.Torrents[id]; ok {
+		m.Streaming = enabled
+		saveMetaState()
+	}
+}
+
+// setMetaLibrary records where the library action put a torrent, or why it
+// failed, so it is only attempted once.
+func setMetaLibrary(id, library string) {
+	metaMutex.Lock()
+	defer metaMutex.Unlock()
+	if m, ok := metaState.Torrents[id]; ok {
+		m.Library = library
+		saveMetaState()
+	}
+}
+
+// restoreMeta copies imported metadata over that of a torrent just added,
+// keeping its new number. The category is set separately.
+func restoreMeta(id string, imported TorrentMeta) {
+	metaMutex.Lock()
+	defer metaMutex.Unlock()
+	if m, ok := metaState.Torrents[id]; ok {
+		if !imported.AddedAt.IsZero() {
+			m.AddedAt = imported.AddedAt
+		}
+		m.CompletedAt = imported.CompletedAt
+		m.Streaming = imported.Streaming
+		m.Library = imported.Library
+		saveMetaState()
+	}
+}
+
+func trackCompletion() {
+	var done []string
+	for _, t := range GetTorrents() {
+		p := t.Stats().Pieces
+		if p.Total > 0 && p.Have == p.Total {
+			done = append(done, t.ID())
+		}
+	}
+	metaMutex.Lock()
+	defer metaMutex.Unlock()
+	changed := false
+	for _, id := range done {
+		if m, ok := metaState.Torrents[id]; ok && m.CompletedAt.IsZero() {
+			m.CompletedAt = time.Now()
+			changed = true
+		}
+	}
+	if changed {
+		saveMetaState()
+	}
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func forgetMeta(id string) {
+	metaMutex.Lock()
+	defer metaMutex.Unlock()
+	if _, ok := metaState.Torrents[id]; ok {
+		delete(metaState.Torrents, id)
+		saveMetaState()
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+
+	"github.com/zeebo/bencode"
+)
+
+const maxTorrentSize = 10 << 20
+
+type Metainfo struct {
+	InfoHash    string     `json:"info_hash"`
+	Name        string     `json:"name"`
+	Length      int64      `json:"length"`
+	PieceLength int64      `json:"piece_length"`
+	Pieces      int        `json:"pieces"`
+	Private     bool       `json:"private"`
+	Comment     string     `json:"comment,omitempty"`
+	Trackers    []string   `json:"trackers,omitempty"`
+	Files       []MetaFile `json:"files"`
+	hashes      string
+}
+
+type MetaFile struct {
+	Path   string `json:"path"`
+	Length int64  `json:"length"`
+}
+
+type torrentFile struct {
+	Info         bencode.RawMessage `bencode:"info"`
+	Announce     string             `bencode:"announce"`
+	AnnounceList [][]string         `bencode:"announce-list"`
+	Comment      string             `bencode:"comment"`
+}
+
+type torrentInfo struct {
+	Name        string `bencode:"name"`
+	PieceLength int64  `bencode:"piece length"`
+	Pieces      string `bencode:"pieces"`
+	Private     int64  `bencode:"private"`
+	Length      int64  `bencode:"length"`
+	Files       []struct {
+		Length int64    `bencode:"length"`
+		Path   []string `bencode:"path"`
+	} `bencode:"files"`
+}
+
+// ParseMetainfo decodes a .torrent file and computes its info hash from
+// the raw bytes of the info dictionary.
+func ParseMetainfo(data []byte) (*Metainfo, error) {
+	if len(data) > maxTorrentSize {
+		return nil, fmt.Errorf("torrent file too large")
+	}
+	var top torrentFile
+	if err := bencode.DecodeBytes(data, &top); err != nil {
+		return nil, fmt.Errorf("invalid torrent file: %v", err)
+	}
+	if len(top.Info) == 0 {
+		return nil, fmt.Errorf("torrent file has no info dictionary")
+	}
+	var info torrentInfo
+	if err := bencode.DecodeBytes(top.Info, &info); err != nil {
+		return nil, fmt.Errorf("invalid info dictionary: %v", err)
+	}
+	sum := sha1.Sum(top.Info)
+	m := &Metainfo{
+		InfoHash:    hex.EncodeToString(sum[:]),
+		Name:        info.Name,
+		PieceLength: info.PieceLength,
+		Pieces:      len(info.Pieces) / sha1.Size,
+		Private:     info.Private == 1,
+		Comment:     top.Comment,
+		hashes:      info.Pieces,
+	}
+	if m.Name == "" {
+		return nil, fmt.Errorf("torrent file has no name")
+	}
+	if !validPathComponent(m.Name) {
+		return nil, fmt.Errorf("torrent file has an invalid name %q", m.Name)
+	}
+	if m.PieceLength <= 0 {
+		return nil, fmt.Errorf("torrent file has an invalid piece length")
+	}
+	if len(info.Pieces)%sha1.Size != 0 {
+		return nil, fmt.Errorf("torrent file has a truncated piece hash list")
+	}
+	if len(info.Files) > 0 {
+		for _, f := range info.Files {
+			if len(f.Path) == 0 || f.Length < 0 || f.Length > math.MaxInt64-m.Length {
+				return nil, fmt.Errorf("torrent file has an invalid file entry")
+			}
+			for _, part := range f.Path {
+				if !validPathComponent(part) {
+					return nil, fmt.Errorf("torrent file has an invalid path component %q", part)
+				}
+			}
+			mf := MetaFile{Path: path.Join(append([]string{m.Name}, f.Path...)...), Length: f.Length}
+			m.Files = append(m.Files, mf)
+			m.Length += mf.Length
+		}
+	} else {
+		if info.Length < 0 {
+			return nil, fmt.Errorf("torrent file has an invalid length")
+		}
+		m.Length = info.Length
+		m.Files = []MetaFile{{Path: m.Name, Length: m.Length}}
+	}
+	want := m.Length / m.PieceLength
+	if m.Length%m.PieceLength != 0 {
+		want++
+	}
+	if int64(m.Pieces) != want {
+		return nil, fmt.Errorf("torrent file has %d pieces, want %d", m.Pieces, want)
+	}
+	if top.Announce != "" {
+		m.Trackers = append(m.Trackers, top.Announce)
+	}
+	for _, tier := range top.AnnounceList {
+		for _, u := range tier {
+			if u != "" && !StringInSlice(u, m.Trackers) {
+				m.Trackers = append(m.Trackers, u)
+			}
+		}
+	}
+	return m, nil
+}
+
+// validPathComponent reports whether s can be used as a single file or
+// directory name without escaping the torrent's directory.
+func validPathComponent(s string) bool {
+	return s != "" && s != "." && s != ".." && !strings.ContainsAny(s, "/\\\x00")
+}
+
+// PieceHash returns the SHA-1 hash of piece i.
+func (m *Metainfo) PieceHash(i int) []byte {
+	return []byte(m.hashes[i*sha1.Size : (i+1)*sha1.Size])
+}
+
+// PieceSize returns the length of piece i, the last piece may be shorter.
+func (m *Metainfo) PieceSize(i int) int64 {
+	if i == m.Pieces-1 {
+		return m.Length - int64(i)*m.PieceLength
+	}
+	return m.PieceLength
+}
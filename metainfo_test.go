@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/zeebo/bencode"
+)
+
+func TestParseMetainfoSingleFile(t *testing.T) {
+	info := "d6:lengthi100e4:name5:a.txt12:piece lengthi64e6:pieces40:" + strings.Repeat("x", 40) + "7:privatei1ee"
+	data := "d8:announce9:http://t/13:announce-listll9:http://t/el9:http://u/ee7:comment2:hi4:info" + info + "e"
+	m, err := ParseMetainfo([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte(info))
+	if m.InfoHash != hex.EncodeToString(sum[:]) {
+		t.Errorf("info hash %s, want hash of the raw info dictionary", m.InfoHash)
+	}
+	if m.Name != "a.txt" || m.Length != 100 || m.Pieces != 2 || !m.Private || m.Comment != "hi" {
+		t.Errorf("unexpected metainfo %+v", m)
+	}
+	if len(m.Trackers) != 2 || m.Trackers[0] != "http://t/" || m.Trackers[1] != "http://u/" {
+		t.Errorf("trackers %v", m.Trackers)
+	}
+	if m.PieceSize(0) != 64 || m.PieceSize(1) != 36 {
+		t.Errorf("piece sizes %d %d", m.PieceSize(0), m.PieceSize(1))
+	}
+}
+
+func TestParseMetainfoMultiFile(t *testing.T) {
+	data, err := bencode.EncodeBytes(map[string]interface{}{
+		"info": map[string]interface{}{
+			"name":         "dir",
+			"piece length": 16,
+			"pieces":       strings.Repeat("y", 20),
+			"files": []interface{}{
+				map[string]interface{}{"length": 3, "path": []string{"a"}},
+				map[string]interface{}{"length": 5, "path": []string{"sub", "b"}},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := ParseMetainfo(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Length != 8 || len(m.Files) != 2 || m.Files[0].Path != "dir/a" || m.Files[1].Path != "dir/sub/b" {
+		t.Errorf("unexpected files %+v, length %d", m.Files, m.Length)
+	}
+}
+
+func TestParseMetainfoInvalid(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"i1e",
+		"d8:announce1:ae",
+		"d4:infod4:name1:aee",
+		"d4:infod12:piece lengthi16eee",
+		"d4:infod4:name1:a12:piece lengthi16e",
+		// names and paths that would escape the torrent directory
+		"d4:infod6:lengthi1e4:name2:..12:piece lengthi16e6:pieces20:" + strings.Repeat("x", 20) + "ee",
+		"d4:infod6:lengthi1e4:name4:/etc12:piece lengthi16e6:pieces20:" + strings.Repeat("x", 20) + "ee",
+		"d4:infod5:filesld6:lengthi1e4:pathl2:..1:aeee4:name1:d12:piece lengthi16e6:pieces20:" + strings.Repeat("x", 20) + "ee",
+		"d4:infod5:filesld6:lengthi1e4:pathl0:eee4:name1:d12:piece lengthi16e6:pieces20:" + strings.Repeat("x", 20) + "ee",
+		"d4:infod5:filesld6:lengthi1e4:pathl3:a/beee4:name1:d12:piece lengthi16e6:pieces20:" + strings.Repeat("x", 20) + "ee",
+		// piece hashes that are truncated or do not cover the length
+		"d4:infod6:lengthi1e4:name1:a12:piece lengthi16e6:pieces19:" + strings.Repeat("x", 19) + "ee",
+		"d4:infod6:lengthi40e4:name1:a12:piece lengthi16e6:pieces20:" + strings.Repeat("x", 20) + "ee",
+		"d4:infod6:lengthi1e4:name1:a12:piece lengthi16e6:pieces40:" + strings.Repeat("x", 40) + "ee",
+	} {
+		if _, err := ParseMetainfo([]byte(data)); err == nil {
+			t.Errorf("%q: expected an error", data)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+// PeerInfo describes a connected peer.
+type PeerInfo struct {
+	Addr      string `json:"addr"`
+	Client    string `json:"client"`
+	Flags     string `json:"flags"`
+	Source    string `json:"source"`
+	Connected string `json:"connected"`
+	DownSpeed string `json:"down_speed"`
+	UpSpeed   string `json:"up_speed"`
+}
+
+// SwarmInfo sums up the swarm of a torrent. Seeders and Leechers are the
+// largest counts any tracker reported, trackers see overlapping swarms so
+// they are not added up. Known peer addresses are split by where they
+// were learned from.
+type SwarmInfo struct {
+	Connected int `json:"connected"`
+	Incoming  int `json:"incoming"`
+	Outgoing  int `json:"outgoing"`
+	Seeders   int `json:"seeders"`
+	Leechers  int `json:"leechers"`
+	Known     int `json:"known"`
+	Tracker   int `json:"tracker"`
+	DHT       int `json:"dht"`
+	PEX       int `json:"pex"`
+}
+
+type TorrentPeers struct {
+	UID   string     `json:"uid"`
+	Swarm SwarmInfo  `json:"swarm"`
+	Peers []PeerInfo `json:"peers"`
+}
+
+// peerFlags describes a peer the way most clients do: D/d we download or
+// want to, U/u we upload or they want to, K/? they choke or we do, O
+// optimistic unchoke, S snubbed and E encrypted.
+func peerFlags(p torrent.Peer) string {
+	flags := ""
+	if p.Downloading {
+		flags += "D"
+	} else if p.ClientInterested {
+		flags += "d"
+	}
+	if !p.ClientChoking && p.PeerInterested {
+		flags += "U"
+	} else if p.PeerInterested {
+		flags += "u"
+	}
+	if p.PeerChoking {
+		flags += "K"
+	}
+	if p.ClientChoking {
+		flags += "?"
+	}
+	if p.OptimisticUnchoked {
+		flags += "O"
+	}
+	if p.Snubbed {
+		flags += "S"
+	}
+	if p.EncryptedStream {
+		flags += "E"
+	}
+	return flags
+}
+
+func GetTorrentPeers(id string) (TorrentPeers, error) {
+	t := GetSession().GetTorrent(id)
+	if t == nil {
+		return TorrentPeers{}, ErrTorrentNotFound
+	}
+	stats := t.Stats()
+	tp := TorrentPeers{
+		UID: id,
+		Swarm: SwarmInfo{
+			Connected: stats.Peers.Total,
+			Incoming:  stats.Peers.Incoming,
+			Outgoing:  stats.Peers.Outgoing,
+			Known:     stats.Addresses.Total,
+			Tracker:   stats.Addresses.Tracker,
+			DHT:       stats.Addresses.DHT,
+			PEX:       stats.Addresses.PEX,
+		},
+		Peers: []PeerInfo{},
+	}
+	tp.Swarm.Seeders, tp.Swarm.Leechers = trackerSwarm(t)
+	for _, p := range t.Peers() {
+		addr := ""
+		if p.Addr != nil {
+			addr = p.Addr.String()
+		}
+		tp.Peers = append(tp.Peers, PeerInfo{
+			Addr:      addr,
+			Client:    p.Client,
+			Flags:     peerFlags(p),
+			Source:    fmt.Sprint(p.Source),
+			Connected: fmt.Sprint(time.Since(p.ConnectedAt).Round(time.Second)),
+			DownSpeed: ByteCountSI(int64(p.DownloadSpeed)) + "/s",
+			UpSpeed:   ByteCountSI(int64(p.UploadSpeed)) + "/s",
+		})
+	}
+	return tp, nil
+}
+
+// trackerSwarm returns the largest seeder and leecher counts any tracker
+// of a torrent reported.
+func trackerSwarm(t *torrent.Torrent) (seeders, leechers int) {
+	for _, tr := range t.Trackers() {
+		if tr.Seeders > seeders {
+			seeders = tr.Seeders
+		}
+		if tr.Leechers > leechers {
+			leechers = tr.Leechers
+		}
+	}
+	return seeders, leechers
+}
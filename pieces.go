@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+// pieceState tracks which pieces of a torrent we have verified on disk
+// ourselves while streaming.
+type pieceState struct {
+	meta *Metainfo
+	have []bool
+	mu   sync.Mutex
+}
+
+// PieceMap is the bitfield of pieces known to be on disk, packed high bit
+// first and base64 encoded, with the share of them in each of up to
+// pieceMapRegions equal regions of the torrent. Available and Missing
+// count the pieces connected peers have and those no peer has.
+type PieceMap struct {
+	UID       string    `json:"uid"`
+	Total     int       `json:"total"`
+	Have      int       `json:"have"`
+	Available int       `json:"available"`
+	Missing   int       `json:"missing"`
+	Bitfield  string    `json:"bitfield"`
+	Regions   []float64 `json:"regions"`
+}
+
+const pieceMapRegions = 100
+
+var (
+	pieceStates = make(map[string]*pieceState)
+	piecesMutex sync.Mutex
+)
+
+// TorrentMetainfo returns the parsed metainfo of a torrent, which is only
+// available once its metadata has been downloaded.
+func TorrentMetainfo(t *torrent.Torrent) (*Metainfo, error) {
+	ps, err := getPieceState(t)
+	if err != nil {
+		return nil, err
+	}
+	return ps.meta, nil
+}
+
+func getPieceState(t *torrent.Torrent) (*pieceState, error) {
+	piecesMutex.Lock()
+	defer piecesMutex.Unlock()
+	if ps, ok := pieceStates[t.ID()]; ok {
+		return ps, nil
+	}
+	if t.Stats().Status == torrent.DownloadingMetadata {
+		return nil, fmt.Errorf("torrent metadata not available yet")
+	}
+	data, err := t.Torrent()
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ParseMetainfo(data)
+	if err != nil {
+		return nil, err
+	}
+	ps := &pieceState{meta: meta, have: make([]bool, meta.Pieces)}
+	pieceStates[t.ID()] = ps
+	return ps, nil
+}
+
+// readAt reads len(buf) bytes at offset off of the torrent data, which is
+// laid out as the concatenation of its files.
+func (ps *pieceState) readAt(dir string, buf []byte, off int64) error {
+	var start int64
+	for _, f := range ps.meta.Files {
+		end := start + f.Length
+		if len(buf) > 0 && off < end && off+int64(len(buf)) > start {
+			from := off - start
+			n := int64(len(buf))
+			if from+n > f.Length {
+				n = f.Length - from
+			}
+			fh, err := os.Open(filepath.Join(dir, filepath.FromSlash(f.Path)))
+			if err != nil {
+				return err
+			}
+			_, err = fh.ReadAt(buf[:n], from)
+			fh.Close()
+			if err != nil {
+				return err
+			}
+			buf = buf[n:]
+			off += n
+		}
+		start = end
+	}
+	if len(buf) > 0 {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
+// verify reports whether piece i is on disk and matches its hash.
+func (ps *pieceState) verify(dir string, i int) bool {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.have[i] {
+		return true
+	}
+	buf := make([]byte, ps.meta.PieceSize(i))
+	if err := ps.readAt(dir, buf, int64(i)*ps.meta.PieceLength); err != nil {
+		return false
+	}
+	sum := sha1.Sum(buf)
+	ps.have[i] = bytes.Equal(sum[:], ps.meta.PieceHash(i))
+	return ps.have[i]
+}
+
+// waitPiece blocks until piece i has been downloaded, the timeout passes
+// or done is closed.
+func (ps *pieceState) waitPiece(dir string, i int, timeout time.Duration, done <-chan struct{}) error {
+	deadline := time.After(timeout)
+	for !ps.verify(dir, i) {
+		select {
+		case <-done:
+			return fmt.Errorf("request cancelled")
+		case <-deadline:
+			return fmt.Errorf("timed out waiting for piece %d", i)
+		case <-time.After(time.Second):
+		}
+	}
+	return nil
+}
+
+// knownPieces returns the pieces known to be on disk without reading any
+// data: all of them once rain has completed the torrent, otherwise those
+// verified while streaming and those lying only in files rain reports as
+// complete.
+func (ps *pieceState) knownPieces(t *torrent.Torrent) []bool {
+	have := make([]bool, ps.meta.Pieces)
+	if p := t.Stats().Pieces; p.Total > 0 && p.Have == p.Total {
+		for i := range have {
+			have[i] = true
+		}
+		return have
+	}
+	ps.mu.Lock()
+	copy(have, ps.have)
+	ps.mu.Unlock()
+	stats, err := t.FileStats()
+	if err != nil || len(stats) != len(ps.meta.Files) {
+		return have
+	}
+	incomplete := make([]bool, len(have))
+	var off int64
+	for i, f := range ps.meta.Files {
+		if f.Length > 0 && stats[i].BytesCompleted < f.Length {
+			for p := off / ps.meta.PieceLength; p <= (off+f.Length-1)/ps.meta.PieceLength && p < int64(len(incomplete)); p++ {
+				incomplete[p] = true
+			}
+		}
+		off += f.Length
+	}
+	for i := range have {
+		have[i] = have[i] || !incomplete[i]
+	}
+	return have
+}
+
+// GetPieceMap returns the pieces known to be on disk. It never hashes
+// data, POST /api/torrent/recheck makes rain verify everything.
+func GetPieceMap(id string) (PieceMap, error) {
+	t := GetSession().GetTorrent(id)
+	if t == nil {
+		return PieceMap{}, ErrTorrentNotFound
+	}
+	ps, err := getPieceState(t)
+	if err != nil {
+		return PieceMap{}, err
+	}
+	known := ps.knownPieces(t)
+	stats := t.Stats().Pieces
+	pm := PieceMap{
+		UID:       id,
+		Total:     len(known),
+		Available: int(stats.Available),
+		Missing:   int(stats.Missing),
+		Regions:   []float64{},
+	}
+	bits := make([]byte, (len(known)+7)/8)
+	for i, ok := range known {
+		if ok {
+			bits[i/8] |= 0x80 >> uint(i%8)
+			pm.Have++
+		}
+	}
+	pm.Bitfield = base64.StdEncoding.EncodeToString(bits)
+	regions := pieceMapRegions
+	if len(known) < regions {
+		regions = len(known)
+	}
+	for r := 0; r < regions; r++ {
+		from, to := r*len(known)/regions, (r+1)*len(known)/regions
+		have := 0
+		for _, ok := range known[from:to] {
+			if ok {
+				have++
+			}
+		}
+		pm.Regions = append(pm.Regions, float64(have)/float64(to-from))
+	}
+	return pm, nil
+}
+
+// RecheckTorrent makes rain re-hash the stored data of a torrent and
+// reports its progress over the WebSocket as recheck messages.
+func RecheckTorrent(id string) error {
+	t := GetSession().GetTorrent(id)
+	if t == nil {
+		return ErrTorrentNotFound
+	}
+	forgetPieces(id)
+	if err := t.Verify(); err != nil {
+		return err
+	}
+	go func() {
+		started := false
+		var stats torrent.Stats
+		for i := 0; ; i++ {
+			time.Sleep(500 * time.Millisecond)
+			// The session may be restarted meanwhile.
+			if t = GetSession().GetTorrent(id); t == nil {
+				return
+			}
+			stats = t.Stats()
+			if stats.Status == torrent.Verifying {
+				started = true
+			} else if started || i > 20 {
+				break
+			}
+			BroadcastMessage("recheck", map[string]interface{}{
+				"uid": id, "status": "checking", "checked": stats.Pieces.Checked, "total": stats.Pieces.Total,
+			})
+		}
+		BroadcastMessage("recheck", map[string]interface{}{
+			"uid": id, "status": "done", "have": stats.Pieces.Have, "total": stats.Pieces.Total,
+		})
+	}()
+	return nil
+}
+
+func forgetPieces(id string) {
+	piecesMutex.Lock()
+	defer piecesMutex.Unlock()
+	delete(pieceStates, id)
+}
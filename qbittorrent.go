@@ -0,0 +1,429 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/cenkalti/rain/torrent"
+	"github.com/gin-gonic/gin"
+)
+
+// The qBittorrent WebUI API v2, enough of it for autobrr, cross-seed and
+// the mobile apps. Torrents are addressed by info hash, tags are our
+// labels and save paths map onto categories. There are no accounts, so
+// any login succeeds and the API is as open as the rest of the server.
+// Files have no priorities and always report the normal priority 1.
+
+const qbitInfiniteETA = 8640000
+
+func QbitLoginHandler(c *gin.Context) {
+	b := make([]byte, 16)
+	rand.Read(b)
+	c.SetCookie("SID", hex.EncodeToString(b), 0, "/", "", false, true)
+	c.String(http.StatusOK, "Ok.")
+}
+
+func QbitLogoutHandler(c *gin.Context) {
+	c.SetCookie("SID", "", -1, "/", "", false, true)
+	c.String(http.StatusOK, "Ok.")
+}
+
+func QbitVersionHandler(c *gin.Context) {
+	c.String(http.StatusOK, "v4.6.0")
+}
+
+func QbitWebAPIVersionHandler(c *gin.Context) {
+	c.String(http.StatusOK, "2.9.3")
+}
+
+func QbitPreferencesHandler(c *gin.Context) {
+	queue := GetQueueConfig()
+	settings := GetSessionSettings()
+	encryption := 0
+	switch settings.Encryption {
+	case EncryptionRequired:
+		encryption = 1
+	case EncryptionDisabled:
+		encryption = 2
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"save_path":                Root,
+		"temp_path_enabled":        false,
+		"queueing_enabled":         queue.MaxDownloads > 0 || queue.MaxSeeds > 0,
+		"max_active_downloads":     queue.MaxDownloads,
+		"max_active_uploads":       queue.MaxSeeds,
+		"max_active_torrents":      queue.MaxDownloads + queue.MaxSeeds,
+		"listen_port":              settings.PortBegin,
+		"max_connec_per_torrent":   settings.MaxPeerDial + settings.MaxPeerAccept,
+		"dht":                      settings.DHT,
+		"pex":                      settings.PEX,
+		"encryption":               encryption,
+		"create_subfolder_enabled": true,
+	})
+}
+
+// qbitTorrents resolves a hashes parameter, hashes separated by | or all.
+func qbitTorrents(hashes string) []*torrent.Torrent {
+	if hashes == "all" {
+		return GetTorrents()
+	}
+	var list []*torrent.Torrent
+	for _, hash := range strings.Split(hashes, "|") {
+		if t := FindTorrentByHash(strings.TrimSpace(hash)); t != nil {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+func qbitState(t *torrent.Torrent) string {
+	stats := t.Stats()
+	complete := stats.Pieces.Total > 0 && stats.Pieces.Have == stats.Pieces.Total
+	suffix := "DL"
+	if complete {
+		suffix = "UP"
+	}
+	switch {
+	case stats.Error != nil:
+		return "error"
+	case isDataBusy(t.ID()):
+		return "moving"
+	}
+	switch stats.Status {
+	case torrent.Stopped, torrent.Stopping:
+		if QueueWaiting(t) {
+			return "queued" + suffix
+		}
+		return "paused" + suffix
+	case torrent.DownloadingMetadata:
+		return "metaDL"
+	case torrent.Allocating:
+		return "allocating"
+	case torrent.Verifying:
+		return "checking" + suffix
+	case torrent.Seeding:
+		if stats.Speed.Upload == 0 {
+			return "stalledUP"
+		}
+		return "uploading"
+	}
+	if stats.Speed.Download == 0 {
+		return "stalledDL"
+	}
+	return "downloading"
+}
+
+func qbitInfo(t *torrent.Torrent) gin.H {
+	stats := t.Stats()
+	meta := GetMeta(t.ID())
+	progress := 0.0
+	if stats.Pieces.Total > 0 {
+		progress = float64(stats.Pieces.Have) / float64(stats.Pieces.Total)
+	}
+	eta := int64(qbitInfiniteETA)
+	if stats.ETA != nil {
+		eta = int64(stats.ETA.Seconds())
+	}
+	var completionOn int64
+	if !meta.CompletedAt.IsZero() {
+		completionOn = meta.CompletedAt.Unix()
+	}
+	tracker := ""
+	for _, tr := range t.Trackers() {
+		if tr.Error == nil && tr.Seeders+tr.Leechers > 0 {
+			tracker = tr.URL
+			break
+		}
+	}
+	ratioLimit := -2.0
+	if p := GetSeedPolicy(t.ID()); p.Mode == SeedRatio {
+		ratioLimit = p.Ratio
+	} else if p.Mode == SeedForever {
+		ratioLimit = -1
+	}
+	magnet, _ := t.Magnet()
+	hash := t.InfoHash().String()
+	// Connected peers can not be told apart into seeds and leechers, so
+	// both pairs of counts are the swarm as seen by the trackers.
+	seeders, leechers := trackerSwarm(t)
+	return gin.H{
+		"hash":           hash,
+		"infohash_v1":    hash,
+		"name":           t.Name(),
+		"size":           stats.Bytes.Total,
+		"total_size":     stats.Bytes.Total,
+		"progress":       progress,
+		"dlspeed":        stats.Speed.Download,
+		"upspeed":        stats.Speed.Upload,
+		"state":          qbitState(t),
+		"category":       meta.Category,
+		"tags":           meta.Label,
+		"save_path":      TorrentDataDir(t.ID()),
+		"content_path":   filepath.Join(TorrentDataDir(t.ID()), t.Name()),
+		"added_on":       meta.AddedAt.Unix(),
+		"completion_on":  completionOn,
+		"eta":            eta,
+		"ratio":          GetRatio(t),
+		"ratio_limit":    ratioLimit,
+		"num_seeds":      seeders,
+		"num_leechs":     leechers,
+		"num_complete":   seeders,
+		"num_incomplete": leechers,
+		"amount_left":    stats.Bytes.Incomplete,
+		"completed":      stats.Bytes.Completed,
+		"downloaded":     stats.Bytes.Downloaded,
+		"uploaded":       stats.Bytes.Uploaded,
+		"priority":       QueuePosition(t.ID()),
+		"magnet_uri":     magnet,
+		"tracker":        tracker,
+		"seeding_time":   int64(stats.SeededFor.Seconds()),
+		"dl_limit":       -1,
+		"up_limit":       -1,
+		"private":        stats.Private,
+	}
+}
+
+func QbitTorrentsInfoHandler(c *gin.Context) {
+	list := []gin.H{}
+	torrents := GetTorrents()
+	if hashes := c.Query("hashes"); hashes != "" {
+		torrents = qbitTorrents(hashes)
+	}
+	category, byCategory := c.GetQuery("category")
+	tag, byTag := c.GetQuery("tag")
+	filter := c.DefaultQuery("filter", "all")
+	for _, t := range torrents {
+		info := qbitInfo(t)
+		if byCategory && info["category"] != category {
+			continue
+		}
+		if byTag && !StringInSlice(tag, SplitList(GetMeta(t.ID()).Label)) {
+			continue
+		}
+		if !qbitFilter(filter, info["state"].(string)) {
+			continue
+		}
+		list = append(list, info)
+	}
+	if key := c.Query("sort"); key != "" {
+		reverse := c.Query("reverse") == "true"
+		sort.SliceStable(list, func(i, j int) bool {
+			if reverse {
+				i, j = j, i
+			}
+			return qbitLess(list[i][key], list[j][key])
+		})
+	}
+	offset, _ := strconv.Atoi(c.Query("offset"))
+	if offset < 0 {
+		offset += len(list)
+	}
+	if offset > 0 && offset <= len(list) {
+		list = list[offset:]
+	}
+	if limit, _ := strconv.Atoi(c.Query("limit")); limit > 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func qbitFilter(filter, state string) bool {
+	switch filter {
+	case "downloading":
+		return strings.HasSuffix(state, "DL") || state == "downloading"
+	case "seeding":
+		return state == "uploading" || state == "stalledUP"
+	case "completed":
+		return strings.HasSuffix(state, "UP") || state == "uploading"
+	case "paused", "stopped":
+		return strings.HasPrefix(state, "paused")
+	case "active":
+		return state == "downloading" || state == "uploading"
+	case "inactive":
+		return state != "downloading" && state != "uploading"
+	case "stalled":
+		return strings.HasPrefix(state, "stalled")
+	case "errored":
+		return state == "error"
+	}
+	return true
+}
+
+func qbitLess(a, b interface{}) bool {
+	switch a := a.(type) {
+	case string:
+		s, _ := b.(string)
+		return a < s
+	case int64:
+		n, _ := b.(int64)
+		return a < n
+	case int:
+		n, _ := b.(int)
+		return a < n
+	case float64:
+		n, _ := b.(float64)
+		return a < n
+	}
+	return false
+}
+
+func QbitTorrentsAddHandler(c *gin.Context) {
+	opts := &AddOptions{
+		Category: c.PostForm("category"),
+		Label:    c.PostForm("tags"),
+		Paused:   c.PostForm("paused") == "true" || c.PostForm("stopped") == "true",
+	}
+	if opts.Category == "" {
+		opts.Category = CategoryForDir(c.PostForm("savepath"))
+	}
+	var added []*torrent.Torrent
+	add := func(src string, data []byte) {
+		if t, _, err := AddTorrentSource(src, data, opts); err == nil {
+			added = append(added, t)
+		}
+	}
+	for _, uri := range strings.Split(c.PostForm("urls"), "\n") {
+		if uri = strings.TrimSpace(uri); uri != "" {
+			add(uri, nil)
+		}
+	}
+	if form, err := c.MultipartForm(); err == nil {
+		for _, fh := range form.File["torrents"] {
+			if data, err := readFormFile(fh, maxTorrentSize); err == nil {
+				add("", data)
+			}
+		}
+	}
+	if len(added) == 0 {
+		c.String(http.StatusOK, "Fails.")
+		return
+	}
+	BroadcastMessage("torrent_added", map[string]string{"status": "ok"})
+	c.String(http.StatusOK, "Ok.")
+}
+
+func QbitPauseHandler(c *gin.Context) {
+	for _, t := range qbitTorrents(c.PostForm("hashes")) {
+		PauseTorrentByID(t.ID())
+	}
+	c.Status(http.StatusOK)
+}
+
+func QbitResumeHandler(c *gin.Context) {
+	for _, t := range qbitTorrents(c.PostForm("hashes")) {
+		ResumeTorrentByID(t.ID())
+	}
+	c.Status(http.StatusOK)
+}
+
+func QbitDeleteHandler(c *gin.Context) {
+	deleteFiles := c.PostForm("deleteFiles") == "true"
+	for _, t := range qbitTorrents(c.PostForm("hashes")) {
+		if _, err := RemoveTorrent(t.ID(), deleteFiles); err != nil {
+			c.String(http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+func QbitFilesHandler(c *gin.Context) {
+	t := FindTorrentByHash(c.Query("hash"))
+	if t == nil {
+		c.String(http.StatusNotFound, "Torrent hash was not found")
+		return
+	}
+	files, err := GetTorrentFiles(t.ID())
+	if err != nil {
+		c.JSON(http.StatusOK, []gin.H{})
+		return
+	}
+	stats, _ := t.FileStats()
+	meta, _ := TorrentMetainfo(t)
+	list := []gin.H{}
+	var offset int64
+	for i, f := range files {
+		length := stats[i].File.Length()
+		progress := 0.0
+		if length > 0 {
+			progress = float64(stats[i].BytesCompleted) / float64(length)
+		}
+		info := gin.H{
+			"index":    i,
+			"name":     f.Path,
+			"size":     length,
+			"progress": progress,
+			"priority": 1,
+			"is_seed":  progress == 1,
+		}
+		if meta != nil && length > 0 {
+			info["piece_range"] = []int64{offset / meta.PieceLength, (offset + length - 1) / meta.PieceLength}
+		}
+		offset += length
+		list = append(list, info)
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+func QbitCategoriesHandler(c *gin.Context) {
+	list := gin.H{}
+	for _, cat := range GetCategories() {
+		dir, _ := categoryDir(cat.Dir)
+		list[cat.Name] = gin.H{"name": cat.Name, "savePath": dir}
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// QbitSetCategoryHandler creates or edits a category. Save paths must be
+// inside the download directory, and the one of an existing category can
+// not change.
+func QbitSetCategoryHandler(c *gin.Context) {
+	cat := Category{Name: c.PostForm("category")}
+	if existing, ok := GetCategory(cat.Name); ok {
+		cat = existing
+	}
+	if save := c.PostForm("savePath"); save != "" {
+		save = filepath.Clean(save)
+		if !WithinDir(Root, save) {
+			c.String(http.StatusBadRequest, "Invalid savePath")
+			return
+		}
+		cat.Dir, _ = filepath.Rel(Root, save)
+	}
+	if err := SetCategory(cat); err != nil {
+		c.String(http.StatusConflict, err.Error())
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func QbitRemoveCategoriesHandler(c *gin.Context) {
+	for _, name := range strings.Split(c.PostForm("categories"), "\n") {
+		if name = strings.TrimSpace(name); name != "" {
+			RemoveCategory(name)
+		}
+	}
+	c.Status(http.StatusOK)
+}
+
+func QbitSetTorrentCategoryHandler(c *gin.Context) {
+	category := c.PostForm("category")
+	if category != "" {
+		if _, ok := GetCategory(category); !ok {
+			c.String(http.StatusConflict, "Category does not exist")
+			return
+		}
+	}
+	for _, t := range qbitTorrents(c.PostForm("hashes")) {
+		if err := SetTorrentCategory(t.ID(), category); err != nil {
+			c.String(http.StatusConflict, err.Error())
+			return
+		}
+	}
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+const queueStateFile = "queue.json"
+
+// Every torrent has a position in Order. Held torrents were paused by the
+// user or finished seeding and are never started by the queue; the others
+// are started in order while there are free download or seed slots. A limit
+// of 0 means unlimited.
+var (
+	queueState = struct {
+		MaxDownloads int             `json:"max_downloads"`
+		MaxSeeds     int             `json:"max_seeds"`
+		Order        []string        `json:"order"`
+		Held         map[string]bool `json:"held"`
+	}{
+		Held: make(map[string]bool),
+	}
+	queueMutex sync.Mutex
+)
+
+type QueueConfig struct {
+	MaxDownloads int `json:"max_downloads"`
+	MaxSeeds     int `json:"max_seeds"`
+}
+
+func InitQueue() {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	if err := loadState(queueStateFile, &queueState); err != nil {
+		log.Printf("Could not load queue: %v", err)
+	}
+	if queueState.Held == nil {
+		queueState.Held = make(map[string]bool)
+	}
+}
+
+func saveQueueState() {
+	if err := saveState(queueStateFile, &queueState); err != nil {
+		log.Printf("Could not save queue: %v", err)
+	}
+}
+
+func GetQueueConfig() QueueConfig {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	return QueueConfig{MaxDownloads: queueState.MaxDownloads, MaxSeeds: queueState.MaxSeeds}
+}
+
+func SetQueueConfig(cfg QueueConfig) error {
+	if cfg.MaxDownloads < 0 || cfg.MaxSeeds < 0 {
+		return fmt.Errorf("slots must not be negative")
+	}
+	queueMutex.Lock()
+	queueState.MaxDownloads = cfg.MaxDownloads
+	queueState.MaxSeeds = cfg.MaxSeeds
+	saveQueueState()
+	queueMutex.Unlock()
+	manageQueue()
+	return nil
+}
+
+// syncQueue adds unknown torrents to the end of the queue and drops removed
+// ones. Unknown torrents that are stopped are held, so whatever was paused
+// before the queue existed stays paused. Must be called with queueMutex
+// held.
+func syncQueue(torrents []*torrent.Torrent) bool {
+	changed := false
+	known := make(map[string]bool)
+	order := queueState.Order[:0]
+	for _, id := range queueState.Order {
+		if GetSession().GetTorrent(id) != nil && !known[id] {
+			order = append(order, id)
+			known[id] = true
+		} else {
+			delete(queueState.Held, id)
+			changed = true
+		}
+	}
+	queueState.Order = order
+	for _, t := range torrents {
+		if !known[t.ID()] {
+			queueState.Order = append(queueState.Order, t.ID())
+			if t.Stats().Status == torrent.Stopped {
+				queueState.Held[t.ID()] = true
+			}
+			changed = true
+		}
+	}
+	return changed
+}
+
+// enqueueTorrent puts a torrent at the end of the queue, ready to start,
+// or held until it is resumed.
+func enqueueTorrent(id string, held bool) {
+	queueMutex.Lock()
+	syncQueue(GetTorrents())
+	if held {
+		queueState.Held[id] = true
+	} else {
+		delete(queueState.Held, id)
+	}
+	saveQueueState()
+	queueMutex.Unlock()
+	manageQueue()
+}
+
+func holdTorrent(id string) {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	if !queueState.Held[id] {
+		queueState.Held[id] = true
+		saveQueueState()
+	}
+}
+
+func releaseTorrent(id string) {
+	queueMutex.Lock()
+	if queueState.Held[id] {
+		delete(queueState.Held, id)
+		saveQueueState()
+	}
+	queueMutex.Unlock()
+	manageQueue()
+}
+
+func isHeld(id string) bool {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	return queueState.Held[id]
+}
+
+// QueueWaiting reports whether a torrent is stopped only because there is
+// no free slot for it.
+func QueueWaiting(t *torrent.Torrent) bool {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	return !queueState.Held[t.ID()] && t.Stats().Status == torrent.Stopped && t.Stats().Error == nil
+}
+
+func QueuePosition(id string) int {
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	for i, qid := range queueState.Order {
+		if qid == id {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func MoveInQueue(id, direction string) error {
+	if GetSession().GetTorrent(id) == nil {
+		return ErrTorrentNotFound
+	}
+	queueMutex.Lock()
+	syncQueue(GetTorrents())
+	pos := -1
+	for i, qid := range queueState.Order {
+		if qid == id {
+			pos = i
+		}
+	}
+	order := queueState.Order
+	switch direction {
+	case "up":
+		if pos > 0 {
+			order[pos-1], order[pos] = order[pos], order[pos-1]
+		}
+	case "down":
+		if pos >= 0 && pos < len(order)-1 {
+			order[pos+1], order[pos] = order[pos], order[pos+1]
+		}
+	case "top":
+		if pos > 0 {
+			copy(order[1:pos+1], order[:pos])
+			order[0] = id
+		}
+	case "bottom":
+		if pos >= 0 {
+			copy(order[pos:], order[pos+1:])
+			order[len(order)-1] = id
+		}
+	default:
+		queueMutex.Unlock()
+		return fmt.Errorf("invalid direction %q", direction)
+	}
+	saveQueueState()
+	queueMutex.Unlock()
+	manageQueue()
+	return nil
+}
+
+// manageQueue starts waiting torrents in queue order while slots are free
+// and stops the ones that no longer fit, for example after the number of
+// slots was lowered.
+func manageQueue() {
+	torrents := GetTorrents()
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+	if syncQueue(torrents) {
+		saveQueueState()
+	}
+	downloads, seeds := 0, 0
+	for _, id := range queueState.Order {
+		t := GetSession().GetTorrent(id)
+		if t == nil || queueState.Held[id] {
+			continue
+		}
+		stats := t.Stats()
+		running := stats.Status != torrent.Stopped
+		if !running && stats.Error != nil {
+			continue
+		}
+		slots, used := queueState.MaxDownloads, &downloads
+		if stats.Pieces.Total > 0 && stats.Pieces.Have == stats.Pieces.Total {
+			slots, used = queueState.MaxSeeds, &seeds
+		}
+		if slots == 0 || *used < slots {
+			*used++
+			if !running && !isDataBusy(id) {
+				if err := t.Start(); err != nil {
+					log.Printf("Could not start queued torrent %s: %v", id, err)
+				}
+			}
+		} else if running && !isDataBusy(id) {
+			t.Stop()
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+const (
+	SeedNone    = "none"
+	SeedRatio   = "ratio"
+	SeedTime    = "time"
+	SeedForever = "forever"
+)
+
+const seedingStateFile = "seeding.json"
+
+type SeedPolicy struct {
+	Mode  string  `json:"mode"`
+	Ratio float64 `json:"ratio,omitempty"`
+	Hours float64 `json:"hours,omitempty"`
+}
+
+// The global policy defaults to none, which stops a torrent as soon as it
+// finishes downloading.
+var (
+	seedState = struct {
+		Global   SeedPolicy            `json:"global"`
+		Torrents map[string]SeedPolicy `json:"torrents"`
+	}{
+		Global:   SeedPolicy{Mode: SeedNone},
+		Torrents: make(map[string]SeedPolicy),
+	}
+	seedMutex sync.RWMutex
+)
+
+func InitSeeding() {
+	seedMutex.Lock()
+	defer seedMutex.Unlock()
+	if err := loadState(seedingStateFile, &seedState); err != nil {
+		log.Printf("Could not load seeding policies: %v", err)
+	}
+	if seedState.Torrents == nil {
+		seedState.Torrents = make(map[string]SeedPolicy)
+	}
+}
+
+func saveSeedState() {
+	if err := saveState(seedingStateFile, &seedState); err != nil {
+		log.Printf("Could not save seeding policies: %v", err)
+	}
+}
+
+func (p SeedPolicy) Validate() error {
+	switch p.Mode {
+	case SeedNone, SeedForever:
+		return nil
+	case SeedRatio:
+		if p.Ratio <= 0 {
+			return fmt.Errorf("ratio must be greater than 0")
+		}
+		return nil
+	case SeedTime:
+		if p.Hours <= 0 {
+			return fmt.Errorf("hours must be greater than 0")
+		}
+		return nil
+	}
+	return fmt.Errorf("invalid seeding mode %q", p.Mode)
+}
+
+// GetSeedPolicy returns the policy in effect for a torrent: its own, the
+// one of its category or the global policy, which is also returned when id
+// is empty.
+func GetSeedPolicy(id string) SeedPolicy {
+	seedMutex.RLock()
+	p, ok := seedState.Torrents[id]
+	global := seedState.Global
+	seedMutex.RUnlock()
+	if ok {
+		return p
+	}
+	if c, ok := GetCategory(GetMeta(id).Category); ok && c.Seed != nil {
+		return *c.Seed
+	}
+	return global
+}
+
+func SetGlobalSeedPolicy(p SeedPolicy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	seedMutex.Lock()
+	defer seedMutex.Unlock()
+	seedState.Global = p
+	saveSeedState()
+	return nil
+}
+
+// SetSeedPolicy overrides the policy of a single torrent, a nil policy
+// makes it follow the global one again.
+func SetSeedPolicy(id string, p *SeedPolicy) error {
+	if GetSession().GetTorrent(id) == nil {
+		return ErrTorrentNotFound
+	}
+	if p != nil {
+		if err := p.Validate(); err != nil {
+			return err
+		}
+	}
+	seedMutex.Lock()
+	defer seedMutex.Unlock()
+	if p == nil {
+		delete(seedState.Torrents, id)
+	} else {
+		seedState.Torrents[id] = *p
+	}
+	saveSeedState()
+	return nil
+}
+
+func GetRatio(t *torrent.Torrent) float64 {
+	b := t.Stats().Bytes
+	downloaded := b.Downloaded
+	if downloaded == 0 {
+		downloaded = b.Total
+	}
+	if downloaded == 0 {
+		return 0
+	}
+	return float64(b.Uploaded) / float64(downloaded)
+}
+
+func seedLimitReached(t *torrent.Torrent, p SeedPolicy) bool {
+	switch p.Mode {
+	case SeedNone:
+		return true
+	case SeedRatio:
+		return GetRatio(t) >= p.Ratio
+	case SeedTime:
+		return t.Stats().SeededFor >= time.Duration(p.Hours*float64(time.Hour))
+	}
+	return false
+}
+
+func enforceSeedingPolicies() {
+	for _, t := range GetTorrents() {
+		if t.Stats().Status != torrent.Seeding {
+			continue
+		}
+		if p := GetSeedPolicy(t.ID()); seedLimitReached(t, p) {
+			log.Printf("Seeding limit (%s) reached for %s, stopping", p.Mode, t.Name())
+			holdTorrent(t.ID())
+			t.Stop()
+		}
+	}
+}
+
+func forgetSeedPolicy(id string) {
+	seedMutex.Lock()
+	defer seedMutex.Unlock()
+	if _, ok := seedState.Torrents[id]; ok {
+		delete(seedState.Torrents, id)
+		saveSeedState()
+	}
+}
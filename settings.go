@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+const settingsStateFile = "settings.json"
+
+// Encryption modes. Rain always accepts encrypted incoming connections, so
+// disabled only stops it from encrypting outgoing ones.
+const (
+	EncryptionDisabled  = "disabled"
+	EncryptionPreferred = "preferred"
+	EncryptionRequired  = "required"
+)
+
+// SessionSettings are the rain session options that can be changed at
+// runtime. Rain reads them only when the session starts. Peer limits are
+// per torrent.
+type SessionSettings struct {
+	PortBegin     uint16 `json:"port_begin"`
+	PortEnd       uint16 `json:"port_end"`
+	DHT           bool   `json:"dht"`
+	PEX           bool   `json:"pex"`
+	Encryption    string `json:"encryption"`
+	MaxPeerDial   int    `json:"max_peer_dial"`
+	MaxPeerAccept int    `json:"max_peer_accept"`
+	MaxOpenFiles  uint64 `json:"max_open_files"`
+}
+
+var (
+	sessionSettings = defaultSessionSettings()
+	settingsMutex   sync.RWMutex
+)
+
+func defaultSessionSettings() SessionSettings {
+	d := torrent.DefaultConfig
+	s := SessionSettings{
+		PortBegin:     d.PortBegin,
+		PortEnd:       d.PortEnd,
+		DHT:           d.DHTEnabled,
+		PEX:           d.PEXEnabled,
+		Encryption:    EncryptionPreferred,
+		MaxPeerDial:   d.MaxPeerDial,
+		MaxPeerAccept: d.MaxPeerAccept,
+		MaxOpenFiles:  d.MaxOpenFiles,
+	}
+	if d.DisableOutgoingEncryption {
+		s.Encryption = EncryptionDisabled
+	} else if d.ForceOutgoingEncryption && d.ForceIncomingEncryption {
+		s.Encryption = EncryptionRequired
+	}
+	return s
+}
+
+// InitSettings loads the saved settings over rain's defaults, so settings
+// missing from the file keep their default value.
+func InitSettings() {
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	if err := loadState(settingsStateFile, &sessionSettings); err != nil {
+		log.Printf("Could not load session settings: %v", err)
+	}
+}
+
+func (s SessionSettings) Validate() error {
+	if s.PortBegin == 0 || s.PortEnd < s.PortBegin {
+		return fmt.Errorf("invalid port range %d-%d", s.PortBegin, s.PortEnd)
+	}
+	if s.PortBegin <= inspectDHTPort && s.PortEnd >= inspectPortBegin {
+		return fmt.Errorf("ports %d-%d are used to inspect magnets", inspectPortBegin, inspectDHTPort)
+	}
+	if s.Encryption != EncryptionDisabled && s.Encryption != EncryptionPreferred && s.Encryption != EncryptionRequired {
+		return fmt.Errorf("invalid encryption mode %q", s.Encryption)
+	}
+	if s.MaxPeerDial < 1 || s.MaxPeerAccept < 1 {
+		return fmt.Errorf("peer limits must be at least 1")
+	}
+	if s.MaxOpenFiles < 1 {
+		return fmt.Errorf("max open files must be at least 1")
+	}
+	return nil
+}
+
+// apply copies the settings into a rain configuration.
+func (s SessionSettings) apply(config *torrent.Config) {
+	config.PortBegin = s.PortBegin
+	config.PortEnd = s.PortEnd
+	config.DHTEnabled = s.DHT
+	config.PEXEnabled = s.PEX
+	config.DisableOutgoingEncryption = s.Encryption == EncryptionDisabled
+	config.ForceOutgoingEncryption = s.Encryption == EncryptionRequired
+	config.ForceIncomingEncryption = s.Encryption == EncryptionRequired
+	config.MaxPeerDial = s.MaxPeerDial
+	config.MaxPeerAccept = s.MaxPeerAccept
+	config.MaxOpenFiles = s.MaxOpenFiles
+}
+
+func GetSessionSettings() SessionSettings {
+	settingsMutex.RLock()
+	defer settingsMutex.RUnlock()
+	return sessionSettings
+}
+
+// SetSessionSettings saves the settings and restarts the session with
+// them. When the session can not start with the new settings, for example
+// because the port is taken, the old ones are restored and the session is
+// reopened with them. Changes are applied one at a time.
+func SetSessionSettings(s SessionSettings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+	restartMutex.Lock()
+	defer restartMutex.Unlock()
+	settingsMutex.Lock()
+	old := sessionSettings
+	if s == old {
+		settingsMutex.Unlock()
+		return nil
+	}
+	sessionSettings = s
+	settingsMutex.Unlock()
+
+	if err := RestartClient(); err != nil {
+		settingsMutex.Lock()
+		sessionSettings = old
+		settingsMutex.Unlock()
+		return fmt.Errorf("session did not start with the new settings: %v", err)
+	}
+	settingsMutex.Lock()
+	defer settingsMutex.Unlock()
+	if err := saveState(settingsStateFile, &sessionSettings); err != nil {
+		log.Printf("Could not save session settings: %v", err)
+	}
+	return nil
+}
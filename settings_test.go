@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestSessionSettingsValidatePorts(t *testing.T) {
+	for _, c := range []struct {
+		begin, end uint16
+		ok         bool
+	}{
+		{50000, 60000, true},
+		{inspectDHTPort + 1, inspectDHTPort + 10, true},
+		{inspectPortBegin - 10, inspectPortBegin - 1, true},
+		{0, 10, false},
+		{100, 10, false},
+		{inspectPortBegin - 10, inspectPortBegin, false},
+		{inspectDHTPort, inspectDHTPort, false},
+		{30000, 40000, false},
+	} {
+		s := SessionSettings{PortBegin: c.begin, PortEnd: c.end, Encryption: EncryptionPreferred, MaxPeerDial: 1, MaxPeerAccept: 1, MaxOpenFiles: 1}
+		if err := s.Validate(); (err == nil) != c.ok {
+			t.Errorf("ports %d-%d: got %v, want ok %v", c.begin, c.end, err, c.ok)
+		}
+	}
+}
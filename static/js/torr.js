@@ -195,12 +195,12 @@ function addTorrent() {
 }
 
 function confirmRemoveTorrent(uid) {
-  Confirm('Remove this torrent and its data?', () => {
+  Confirm('Remove this torrent and delete its data?', () => {
     $.ajax({
       url: '/api/remove',
       type: 'POST',
-      data: { uid: uid },
-      success: () => Toast('Torrent removed', 'success'),
+      data: { uid: uid, delete_data: true },
+      success: () => Toast('Torrent and data removed', 'success'),
       error: (xhr) => Toast('Error: ' + xhr.responseText, 'error')
     });
   });
@@ -227,7 +227,7 @@ function stopAll() {
 }
 
 function removeAll() {
-  Confirm('Remove ALL torrents?', () => {
+  Confirm('Remove ALL torrents? Downloaded files are kept.', () => {
     $.post('/api/removeall').done(() => Toast('All removed', 'success'));
   });
 }
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamTimeout is how long a read waits for a missing piece.
+const streamTimeout = 2 * time.Minute
+
+// streamReader serves a file of an incomplete torrent, blocking each read
+// until the piece it falls into has been downloaded and verified.
+type streamReader struct {
+	ps   *pieceState
+	dir  string
+	f    *os.File
+	base int64
+	size int64
+	pos  int64
+	done <-chan struct{}
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.size-r.pos {
+		p = p[:r.size-r.pos]
+	}
+	pl := r.ps.meta.PieceLength
+	abs := r.base + r.pos
+	if end := (abs/pl + 1) * pl; int64(len(p)) > end-abs {
+		p = p[:end-abs]
+	}
+	if err := r.ps.waitPiece(r.dir, int(abs/pl), streamTimeout, r.done); err != nil {
+		return 0, err
+	}
+	n, err := r.f.ReadAt(p, r.pos)
+	r.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+func (r *streamReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence")
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position")
+	}
+	r.pos = offset
+	return offset, nil
+}
+
+// SetStreaming toggles streaming mode for a torrent, in which the file
+// server hands out its files before it completes.
+func SetStreaming(id string, enabled bool) error {
+	if GetSession().GetTorrent(id) == nil {
+		return ErrTorrentNotFound
+	}
+	setMetaStreaming(id, enabled)
+	return nil
+}
+
+// ServeTorrentStream serves path if it is a file of an incomplete torrent
+// in streaming mode and reports whether it did.
+func ServeTorrentStream(c *gin.Context, path string) bool {
+	realPath, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false
+	}
+	for _, t := range GetTorrents() {
+		if !GetMeta(t.ID()).Streaming {
+			continue
+		}
+		p := t.Stats().Pieces
+		if p.Total > 0 && p.Have == p.Total {
+			continue
+		}
+		dir, err := filepath.EvalSymlinks(TorrentDataDir(t.ID()))
+		if err != nil {
+			continue
+		}
+		if !WithinDir(dir, realPath) {
+			continue
+		}
+		rel, _ := filepath.Rel(dir, realPath)
+		ps, err := getPieceState(t)
+		if err != nil {
+			continue
+		}
+		var base int64
+		for _, mf := range ps.meta.Files {
+			if mf.Path == filepath.ToSlash(rel) {
+				f, err := os.Open(realPath)
+				if err != nil {
+					c.String(http.StatusInternalServerError, err.Error())
+					return true
+				}
+				defer f.Close()
+				r := &streamReader{ps: ps, dir: dir, f: f, base: base, size: mf.Length, done: c.Request.Context().Done()}
+				http.ServeContent(c.Writer, c.Request, filepath.Base(realPath), time.Time{}, r)
+				return true
+			}
+			base += mf.Length
+		}
+	}
+	return false
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// InitSupervisor starts the background loop that enforces the per-torrent
+// rules the rain session itself knows nothing about.
+func InitSupervisor() {
+	go superviseTorrents()
+}
+
+func superviseTorrents() {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		trackCompletion()
+		applyLibraryActions()
+		applyPendingTrackers()
+		enforceSeedingPolicies()
+		if err := applySpeedLimits(); err != nil {
+			log.Printf("Could not apply speed limits: %v", err)
+		}
+		manageQueue()
+		detectHookEvents()
+	}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
@@ -11,84 +14,421 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/rain/torrent"
 )
 
 var (
-	client   *torrent.Session
-	hClient  = &http.Client{Timeout: time.Second * 10}
-	Trackers []string
+	ErrTorrentNotFound = fmt.Errorf("torrent not found")
+	ErrTorrentExists   = fmt.Errorf("torrent already exists")
 )
 
-func InitClient() *torrent.Session {
+var (
+	// session is replaced when it is restarted, read it with GetSession
+	// and look torrents up by ID again instead of keeping them around.
+	session      *torrent.Session
+	sessionMutex sync.RWMutex
+	// restartMutex serializes restarts, from reading the settings the
+	// new configuration is made of to restoring them when it fails. It is
+	// taken before settingsMutex, bandwidthMutex and sessionMutex.
+	restartMutex sync.Mutex
+	// appliedConfig is the configuration the running session was opened with.
+	appliedConfig torrent.Config
+	hClient       = &http.Client{Timeout: time.Second * 10}
+)
+
+// sessionReopenAttempts is how often a restart retries the last working
+// configuration, a second apart, before giving up.
+const sessionReopenAttempts = 10
+
+func newClientConfig() torrent.Config {
 	config := torrent.DefaultConfig
 	config.DataDir = Root + "/torrents/"
 	config.Database = Root + "/torrents.db"
 	// Suppress verbose announce errors
 	config.Debug = false
+	limit := ActiveSpeedLimit(time.Now())
+	config.SpeedLimitDownload = limit.Download
+	config.SpeedLimitUpload = limit.Upload
+	GetSessionSettings().apply(&config)
+	if blocklistURL != "" {
+		config.BlocklistURL = blocklistURL
+		config.BlocklistUpdateInterval = blocklistPoll
+		config.BlocklistEnabledForTrackers = true
+		config.BlocklistEnabledForOutgoingConnections = true
+		config.BlocklistEnabledForIncomingConnections = true
+	}
+	return config
+}
+
+func InitClient() *torrent.Session {
+	config := newClientConfig()
 	client, err := torrent.NewSession(config)
 	if err != nil {
 		log.Fatal(err)
 	}
+	appliedConfig = config
+	appliedLimit = SpeedLimit{Download: config.SpeedLimitDownload, Upload: config.SpeedLimitUpload}
 	return client
 }
 
+// GetSession returns the running rain session.
+func GetSession() *torrent.Session {
+	sessionMutex.RLock()
+	defer sessionMutex.RUnlock()
+	return session
+}
+
+// RestartClient closes the session and opens a new one with the current
+// configuration. Torrents live in torrents.db and resume on startup. It
+// must be called with restartMutex held.
+func RestartClient() error {
+	config := newClientConfig()
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+	return restartClient(config)
+}
+
+// restartClient must be called with sessionMutex held. When the new
+// configuration does not work the last working one is reopened, so there
+// is always a running session afterwards.
+func restartClient(config torrent.Config) error {
+	if err := session.Close(); err != nil {
+		log.Printf("Error closing session: %v", err)
+	}
+	c, err := torrent.NewSession(config)
+	if err != nil {
+		session = reopenSession(appliedConfig)
+		return err
+	}
+	session = c
+	appliedConfig = config
+	appliedLimit = SpeedLimit{Download: config.SpeedLimitDownload, Upload: config.SpeedLimitUpload}
+	return nil
+}
+
+// reopenSession opens a session with a configuration that worked before,
+// retrying while the closed session releases its ports. Running on without
+// a session is not possible, so it exits like InitClient when it fails.
+func reopenSession(config torrent.Config) *torrent.Session {
+	var err error
+	for i := 0; i < sessionReopenAttempts; i++ {
+		var c *torrent.Session
+		if c, err = torrent.NewSession(config); err == nil {
+			return c
+		}
+		log.Printf("Error reopening session: %v", err)
+		time.Sleep(time.Second)
+	}
+	log.Fatalf("Could not reopen session: %v", err)
+	return nil
+}
+
 type TorrentData struct {
-	Name     string `json:"name,omitempty"`
-	Size     string `json:"size,omitempty"`
-	Status   string `json:"status,omitempty"`
-	Magnet   string `json:"magnet,omitempty"`
-	ID       string `json:"id,omitempty"`
-	UID      string `json:"uid,omitempty"`
-	Perc     string `json:"perc,omitempty"`
-	Eta      string `json:"eta,omitempty"`
-	Speed    string `json:"speed,omitempty"`
-	Progress string `json:"progress,omitempty"`
-	Icon     string `json:"icon,omitempty"`
-	Path     string `json:"path,omitempty"`
-}
-
-func AddTorrentByMagnet(magnet string) (bool, error) {
+	Name      string `json:"name,omitempty"`
+	Size      string `json:"size,omitempty"`
+	Status    string `json:"status,omitempty"`
+	Magnet    string `json:"magnet,omitempty"`
+	ID        string `json:"id,omitempty"`
+	UID       string `json:"uid,omitempty"`
+	Perc      string `json:"perc,omitempty"`
+	Eta       string `json:"eta,omitempty"`
+	Speed     string `json:"speed,omitempty"`
+	Progress  string `json:"progress,omitempty"`
+	Icon      string `json:"icon,omitempty"`
+	Path      string `json:"path,omitempty"`
+	Ratio     string `json:"ratio,omitempty"`
+	SeedTime  string `json:"seed_time,omitempty"`
+	Added     string `json:"added,omitempty"`
+	Completed string `json:"completed,omitempty"`
+	Source    string `json:"source,omitempty"`
+	Label     string `json:"label,omitempty"`
+	Notes     string `json:"notes,omitempty"`
+	Category  string `json:"category,omitempty"`
+	Queue     string `json:"queue,omitempty"`
+	Streaming bool   `json:"streaming,omitempty"`
+}
+
+// AddOptions holds the settings applied to a torrent right after it is
+// added to the session.
+type AddOptions struct {
+	Category string
+	Label    string
+	Notes    string
+	Source   string
+	// Paused keeps the torrent stopped until it is resumed.
+	Paused bool
+}
+
+// AddTorrent adds a torrent from a magnet link, an http(s) URL pointing to
+// a .torrent file, or base64 encoded .torrent data.
+func AddTorrent(src string, opts *AddOptions) (bool, error) {
+	src = strings.TrimSpace(src)
+	if strings.HasPrefix(src, "magnet:") {
+		return AddTorrentByMagnet(src, opts)
+	}
+	if strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://") {
+		return AddTorrentByURL(src, opts)
+	}
+	data, err := base64.StdEncoding.DecodeString(src)
+	if err != nil {
+		return false, fmt.Errorf("unsupported torrent source")
+	}
+	return AddTorrentByFile(data, opts)
+}
+
+func AddTorrentByMagnet(magnet string, opts *AddOptions) (bool, error) {
+	if err := opts.validate(); err != nil {
+		return false, err
+	}
 	if CheckDuplicateTorrent(magnet) {
-		return false, fmt.Errorf("torrent already exists")
+		return false, ErrTorrentExists
+	}
+	var m *torrent.Torrent
+	var err error
+	// Reuse the metadata of a magnet that was inspected before.
+	hash := ParseHashFromMagnet(magnet)
+	if data := inspectedTorrent(hash); data != nil {
+		m, err = GetSession().AddTorrent(bytes.NewReader(data), &torrent.AddTorrentOptions{Stopped: true})
+		forgetInspected(hash)
+	} else {
+		m, err = GetSession().AddURI(magnet, &torrent.AddTorrentOptions{Stopped: true})
+	}
+	if err != nil {
+		return false, err
+	}
+	onTorrentAdded(m, magnet, opts)
+	return true, nil
+}
+
+func AddTorrentByFile(data []byte, opts *AddOptions) (bool, error) {
+	meta, err := ParseMetainfo(data)
+	if err != nil {
+		return false, err
+	}
+	if err := opts.validate(); err != nil {
+		return false, err
+	}
+	if CheckDuplicateTorrent(meta.InfoHash) {
+		return false, ErrTorrentExists
 	}
-	m, err := client.AddURI(magnet, &torrent.AddTorrentOptions{StopAfterDownload: true})
+	t, err := GetSession().AddTorrent(bytes.NewReader(data), &torrent.AddTorrentOptions{Stopped: true})
 	if err != nil {
 		return false, err
 	}
-	for i := range Trackers {
-		m.AddTracker(Trackers[i])
+	source := "file:" + meta.Name + ".torrent"
+	if opts != nil && opts.Source != "" {
+		source = opts.Source
 	}
+	onTorrentAdded(t, source, opts)
 	return true, nil
 }
 
-func GetTrakers() {
-	url := "https://raw.githubusercontent.com/ngosang/trackerslist/master/trackers_all.txt"
-	resp, err := hClient.Get(url)
+func AddTorrentByURL(uri string, opts *AddOptions) (bool, error) {
+	data, err := FetchTorrentFile(uri)
+	if err != nil {
+		return false, err
+	}
+	withSource := AddOptions{}
+	if opts != nil {
+		withSource = *opts
+	}
+	withSource.Source = uri
+	return AddTorrentByFile(data, &withSource)
+}
+
+func FetchTorrentFile(uri string) ([]byte, error) {
+	u, err := url.Parse(uri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid torrent url")
+	}
+	resp, err := hClient.Get(u.String())
 	if err != nil {
-		return
+		return nil, err
 	}
 	defer resp.Body.Close()
-	t, _ := ioutil.ReadAll(resp.Body)
-	for _, line := range strings.Split(string(t), "\n") {
-		if strings.HasPrefix(line, "udp://") {
-			Trackers = append(Trackers, line)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching torrent: %s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxTorrentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxTorrentSize {
+		return nil, fmt.Errorf("torrent file too large")
+	}
+	return data, nil
+}
+
+func (opts *AddOptions) validate() error {
+	if opts == nil || opts.Category == "" {
+		return nil
+	}
+	if _, ok := GetCategory(opts.Category); !ok {
+		return fmt.Errorf("category not found")
+	}
+	return nil
+}
+
+// onTorrentAdded applies the add options to a torrent that was added in
+// the stopped state, then hands it to the queue.
+func onTorrentAdded(t *torrent.Torrent, source string, opts *AddOptions) {
+	newMeta(t.ID(), source, opts)
+	addPublicTrackers(t, source)
+	if opts == nil {
+		opts = &AddOptions{}
+	}
+	if c, ok := GetCategory(opts.Category); ok {
+		if err := SetTorrentCategory(t.ID(), c.Name); err != nil {
+			log.Printf("Could not move %s to category %s: %v", t.ID(), c.Name, err)
+		}
+	}
+	enqueueTorrent(t.ID(), opts.Paused)
+	emitEvent(HookEvent{
+		Event:    "torrent_added",
+		Name:     t.Name(),
+		Path:     filepath.Join(TorrentDataDir(t.ID()), t.Name()),
+		Hash:     t.InfoHash().String(),
+		Size:     t.Stats().Bytes.Total,
+		Category: opts.Category,
+	})
+}
+
+// forgetTorrent drops everything stored alongside a removed torrent.
+func forgetTorrent(id string) {
+	forgetMeta(id)
+	forgetSeedPolicy(id)
+	forgetPieces(id)
+}
+
+// RemovedTorrent reports what happened to a removed torrent and its data:
+// "deleted", "kept" at Path, or "none" when nothing was downloaded yet.
+type RemovedTorrent struct {
+	UID    string `json:"uid"`
+	Name   string `json:"name,omitempty"`
+	Status string `json:"status"`
+	Data   string `json:"data,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// RemoveTorrent removes a torrent from the session. With deleteData its
+// files are deleted along with the parent directories under Root that are
+// left empty. Otherwise the files are kept: rain deletes the data
+// directory of a removed torrent, so they are first moved out of it, next
+// to where it was, or into Root when it is rain's own.
+func RemoveTorrent(id string, deleteData bool) (RemovedTorrent, error) {
+	res := RemovedTorrent{UID: id, Status: "error"}
+	t := GetSession().GetTorrent(id)
+	if t == nil {
+		res.Error = ErrTorrentNotFound.Error()
+		return res, ErrTorrentNotFound
+	}
+	res.Name = t.Name()
+	fail := func(err error) (RemovedTorrent, error) {
+		res.Error = err.Error()
+		return res, err
+	}
+	if !markDataBusy(id) {
+		return fail(fmt.Errorf("torrent data is being moved"))
+	}
+	defer unmarkDataBusy(id)
+
+	res.Data = "none"
+	data := ""
+	if t.Name() != "" {
+		data = filepath.Join(TorrentDataDir(id), t.Name())
+		if _, err := os.Lstat(data); err != nil {
+			data = ""
+		}
+	}
+	// Files seeded from where they were created belong to the user, only
+	// the link to them goes with the torrent.
+	if data != "" && (!deleteData || seededInPlace(id)) {
+		kept, err := detachTorrentData(t, data)
+		if err != nil {
+			return fail(fmt.Errorf("could not keep data: %v", err))
 		}
+		res.Data = "kept"
+		res.Path = ServerPath(kept)
 	}
+	err := GetSession().RemoveTorrent(id)
+	forgetTorrent(id)
+	if err != nil {
+		return fail(err)
+	}
+	if data != "" && res.Data != "kept" {
+		if err := os.RemoveAll(data); err != nil {
+			return fail(err)
+		}
+		removeEmptyParents(filepath.Dir(data))
+		res.Data = "deleted"
+		res.Path = ServerPath(data)
+	}
+	res.Status = "ok"
+	return res, nil
 }
 
-func DeleteTorrentByID(id string) (bool, error) {
-	if Torr := client.GetTorrent(id); Torr != nil {
-		err := client.RemoveTorrent(id)
-		return true, err
+// detachTorrentData moves the files of a torrent out of the directory rain
+// deletes with it and returns where they are now. Data in a shared
+// directory is only linked from rain's and stays where it is.
+func detachTorrentData(t *torrent.Torrent, data string) (string, error) {
+	dir := filepath.Dir(data)
+	if seededInPlace(t.ID()) {
+		return data, nil
+	}
+	if _, err := stopAndWait(t); err != nil {
+		return "", err
+	}
+	parent := filepath.Dir(dir)
+	if dir == filepath.Join(Root, "torrents", t.ID()) {
+		parent = Root
+	}
+	dest := uniquePath(filepath.Join(parent, filepath.Base(data)))
+	if err := moveDir(data, dest); err != nil {
+		return "", err
+	}
+	if dir != filepath.Join(Root, "torrents", t.ID()) {
+		// Rain only removes the link to a placed data directory.
+		os.Remove(dir)
+	}
+	return dest, nil
+}
+
+// uniquePath appends a number to p until nothing exists there.
+func uniquePath(p string) string {
+	ext := filepath.Ext(p)
+	base := strings.TrimSuffix(p, ext)
+	for i := 1; ; i++ {
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			return p
+		}
+		p = fmt.Sprintf("%s (%d)%s", base, i, ext)
+	}
+}
+
+// removeEmptyParents removes dir and its parents while they are empty,
+// stopping at Root and the directories it keeps.
+func removeEmptyParents(dir string) {
+	for {
+		if !WithinDir(Root, dir) || filepath.Clean(dir) == filepath.Clean(Root) {
+			return
+		}
+		if dir == filepath.Join(Root, "torrents") || dir == StateDir {
+			return
+		}
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
 	}
-	return false, nil
 }
 
 func PauseTorrentByID(id string) (bool, error) {
-	if t := client.GetTorrent(id); t != nil {
+	if t := GetSession().GetTorrent(id); t != nil {
+		holdTorrent(id)
 		err := t.Stop()
 		if err != nil {
 			return false, err
@@ -98,102 +438,106 @@ func PauseTorrentByID(id string) (bool, error) {
 	return false, nil
 }
 
+// ResumeTorrentByID hands the torrent back to the queue, which starts it
+// right away if a slot is free.
 func ResumeTorrentByID(id string) (bool, error) {
-	if t := client.GetTorrent(id); t != nil {
-		err := t.Start()
-		if err != nil {
-			return false, err
-		}
+	if t := GetSession().GetTorrent(id); t != nil {
+		releaseTorrent(id)
 		return true, nil
 	}
 	return false, nil
 }
 
 func GetTorrentByID(id string) TorrentData {
-	t := client.GetTorrent(id)
-	Stats, Icon := GetStats(t)
-	Perc := GetDownloadPercentage(t)
-	var Path = ServerPath(GetTorrentPath(t))
-	if f, err := os.Stat(Path); err != nil && os.IsNotExist(err) || !f.IsDir() {
-		Path = strings.Replace(Path, filepath.Base(Path), "", 1)
-	}
-	if t != nil {
-		torrent := TorrentData{
-			Name:     t.Stats().Name,
-			Size:     ByteCountSI(t.Stats().Bytes.Total),
-			Status:   Stats,
-			Magnet:   t.Stats().InfoHash.String(),
-			UID:      t.ID(),
-			Perc:     Perc,
-			Eta:      fmt.Sprint(t.Stats().ETA),
-			Speed:    GetDownloadSpeed(t),
-			Progress: GetProgress(Perc),
-			Icon:     Icon,
-			Path:     Path,
-		}
-		return torrent
+	if t := GetSession().GetTorrent(id); t != nil {
+		return newTorrentData(t)
 	}
 	return TorrentData{}
 }
 
 func StopAll() {
-	client.StopAll()
+	for _, t := range GetTorrents() {
+		holdTorrent(t.ID())
+	}
+	GetSession().StopAll()
 }
 
 func StartAll() {
-	client.StartAll()
+	for _, t := range GetTorrents() {
+		releaseTorrent(t.ID())
+	}
 }
 
-func DropAllTorrents() error {
+// DropAllTorrents removes every torrent like RemoveTorrent.
+func DropAllTorrents(deleteData bool) []RemovedTorrent {
 	log.Println("Dropping all torrents")
-	var err error
+	removed := []RemovedTorrent{}
 	for _, t := range GetTorrents() {
-		err = client.RemoveTorrent(t.ID())
+		res, _ := RemoveTorrent(t.ID(), deleteData)
+		removed = append(removed, res)
 	}
-	return err
+	return removed
 }
 
 func GetTorrents() []*torrent.Torrent {
-	return client.ListTorrents()
+	return GetSession().ListTorrents()
 }
 
+// GetTorrentPath returns where the data of a torrent is served below
+// /downloads, or its absolute path when it was moved to a library outside
+// Root, which is not served.
 func GetTorrentPath(Torr *torrent.Torrent) string {
-	return "/downloads/torrents/" + Torr.ID() + "/" + Torr.Stats().Name
+	dir := TorrentDataDir(Torr.ID())
+	if !WithinDir(Root, dir) {
+		return filepath.Join(dir, Torr.Stats().Name)
+	}
+	return "/downloads" + ServerPath(dir) + "/" + Torr.Stats().Name
+}
+
+func newTorrentData(t *torrent.Torrent) TorrentData {
+	Perc := GetDownloadPercentage(t)
+	Name := t.Stats().Name
+	if Name == "" {
+		Name = "fetching metadata..."
+	}
+	var Path = GetTorrentPath(t)
+	if f, err := os.Stat(filepath.Join(TorrentDataDir(t.ID()), t.Stats().Name)); err != nil || !f.IsDir() {
+		Path = strings.TrimSuffix(Path, filepath.Base(Path))
+	}
+	Stats, Icon := GetStats(t)
+	Meta := GetMeta(t.ID())
+	return TorrentData{
+		Name:      Name,
+		Size:      ByteCountSI(t.Stats().Bytes.Total),
+		Status:    Stats,
+		Magnet:    t.Stats().InfoHash.String(),
+		UID:       t.ID(),
+		Perc:      Perc,
+		Eta:       fmt.Sprint(t.Stats().ETA),
+		Speed:     GetDownloadSpeed(t),
+		Progress:  GetProgress(Perc),
+		Icon:      Icon,
+		Path:      Path,
+		Ratio:     fmt.Sprintf("%.2f", GetRatio(t)),
+		SeedTime:  fmt.Sprint(t.Stats().SeededFor.Round(time.Second)),
+		ID:        strconv.Itoa(Meta.Seq),
+		Added:     formatTime(Meta.AddedAt),
+		Completed: formatTime(Meta.CompletedAt),
+		Source:    Meta.Source,
+		Label:     Meta.Label,
+		Notes:     Meta.Notes,
+		Category:  Meta.Category,
+		Queue:     strconv.Itoa(QueuePosition(t.ID())),
+		Streaming: Meta.Streaming,
+	}
 }
 
 func GetAllTorrents() []TorrentData {
 	var Torrents []TorrentData
 	for _, t := range GetTorrents() {
-		Perc := GetDownloadPercentage(t)
-		Name := t.Stats().Name
-		Icon := "bi bi-pause-circle"
-		if Name == "" {
-			Name = "fetching metadata..."
-		}
-		var Path = ServerPath(GetTorrentPath(t))
-		if f, err := os.Stat(Path); err != nil && os.IsNotExist(err) || !f.IsDir() {
-			Path = strings.Replace(Path, filepath.Base(Path), "", 1)
-		}
-		Stats, Icon := GetStats(t)
-		Torrents = append(Torrents, TorrentData{
-			Name:     Name,
-			Size:     ByteCountSI(t.Stats().Bytes.Total),
-			Status:   Stats,
-			Magnet:   t.Stats().InfoHash.String(),
-			UID:      t.ID(),
-			Perc:     Perc,
-			Eta:      fmt.Sprint(t.Stats().ETA),
-			Speed:    GetDownloadSpeed(t),
-			Progress: GetProgress(Perc),
-			Icon:     Icon,
-			Path:     Path,
-		})
-	}
-	Torrents = SortAlpha(Torrents)
-	for i := range Torrents {
-		Torrents[i].ID = strconv.Itoa(i + 1)
-	}
-	return Torrents
+		Torrents = append(Torrents, newTorrentData(t))
+	}
+	return SortAlpha(Torrents)
 }
 
 func GetDownloadPercentage(torr *torrent.Torrent) string {
@@ -210,7 +554,7 @@ func GetProgress(perc string) string {
 }
 
 func GetTorrentSize(id string) int64 {
-	torr := client.GetTorrent(id)
+	torr := GetSession().GetTorrent(id)
 	if torr != nil {
 		if torr.Stats().Bytes.Total != 0 {
 			return torr.Stats().Bytes.Total
@@ -227,14 +571,70 @@ func GetDownloadSpeed(t *torrent.Torrent) string {
 	}
 }
 
-func CheckDuplicateTorrent(magnet string) bool {
-	magnet = ParseHashFromMagnet(magnet)
+// FindTorrentByHash returns the torrent with the given hex info hash.
+func FindTorrentByHash(hash string) *torrent.Torrent {
+	hash = strings.ToLower(hash)
 	for _, t := range GetTorrents() {
-		if strings.ToLower(t.Stats().InfoHash.String()) == magnet {
-			return true
+		if strings.ToLower(t.Stats().InfoHash.String()) == hash {
+			return t
+		}
+	}
+	return nil
+}
+
+// AddTorrentSource adds a torrent like AddTorrent, or from raw .torrent
+// data when data is set, and returns it. A torrent already in the session
+// is returned with added set to false.
+func AddTorrentSource(src string, data []byte, opts *AddOptions) (t *torrent.Torrent, added bool, err error) {
+	src = strings.TrimSpace(src)
+	if data == nil && (strings.HasPrefix(src, "http://") || strings.HasPrefix(src, "https://")) {
+		if data, err = FetchTorrentFile(src); err != nil {
+			return nil, false, err
 		}
+		withSource := AddOptions{}
+		if opts != nil {
+			withSource = *opts
+		}
+		withSource.Source = src
+		opts = &withSource
+	}
+	var hash string
+	if data != nil {
+		meta, err := ParseMetainfo(data)
+		if err != nil {
+			return nil, false, err
+		}
+		hash = meta.InfoHash
+	} else if strings.HasPrefix(src, "magnet:") {
+		hash = ParseHashFromMagnet(src)
+	} else {
+		return nil, false, fmt.Errorf("unsupported torrent source")
+	}
+	if t := FindTorrentByHash(hash); t != nil {
+		return t, false, nil
+	}
+	if data != nil {
+		_, err = AddTorrentByFile(data, opts)
+	} else {
+		_, err = AddTorrentByMagnet(src, opts)
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if t = FindTorrentByHash(hash); t == nil {
+		return nil, false, fmt.Errorf("torrent was added but can not be found")
+	}
+	return t, true, nil
+}
+
+// CheckDuplicateTorrent reports whether a torrent with the info hash of the
+// given magnet link, or the given hex info hash, is already in the session.
+func CheckDuplicateTorrent(magnet string) bool {
+	hash := strings.ToLower(magnet)
+	if strings.HasPrefix(hash, "magnet:") {
+		hash = ParseHashFromMagnet(magnet)
 	}
-	return false
+	return hash != "" && FindTorrentByHash(hash) != nil
 }
 
 func ParseHashFromMagnet(magnet string) string {
@@ -245,7 +645,6 @@ func ParseHashFromMagnet(magnet string) string {
 		args = []string{magnet}
 	}
 	argv := strings.Split(args[0], "btih:")
-	log.Println(argv)
 	if len(argv) <= 1 {
 		return ""
 	}
@@ -256,6 +655,10 @@ func GetStats(torr *torrent.Torrent) (string, string) {
 	if torr != nil {
 		if torr.Stats().Bytes.Total == 0 || torr.Stats().Status == torrent.DownloadingMetadata {
 			return "Fetching Metadata", "bi bi-meta"
+		} else if QueueWaiting(torr) {
+			return "Queued", "bi bi-hourglass"
+		} else if torr.Stats().Status == torrent.Seeding {
+			return "Seeding", "bi bi-cloud-upload"
 		} else if torr.Stats().Bytes.Downloaded >= torr.Stats().Bytes.Total {
 			return "Completed", "bi bi-cloud-upload"
 		} else if torr.Stats().Status == torrent.Downloading {
@@ -314,8 +717,13 @@ func GetLenTorrents() int {
 	return len(GetTorrents())
 }
 
-func init() {
+// InitTorrents prepares the download directory and starts the session.
+func InitTorrents() {
 	PrepareWD()
-	GetTrakers()
-	client = InitClient()
+	InitTrackers()
+	InitSettings()
+	InitBlocklist()
+	repairLibraryMoves()
+	session = InitClient()
+	syncMeta()
 }
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+const trackersStateFile = "trackers.json"
+
+func trackersCacheFile() string {
+	return filepath.Join(StateDir, "trackers.txt")
+}
+
+// TrackerSource says where the list of public trackers added to every
+// torrent comes from. trackersCacheFile is read when URL can not be
+// fetched and is rewritten after every successful fetch.
+type TrackerSource struct {
+	URL   string  `json:"url"`
+	Hours float64 `json:"hours"`
+}
+
+type TrackerInfo struct {
+	URL          string `json:"url"`
+	Status       string `json:"status"`
+	Seeders      int    `json:"seeders"`
+	Leechers     int    `json:"leechers"`
+	Error        string `json:"error,omitempty"`
+	Warning      string `json:"warning,omitempty"`
+	LastAnnounce string `json:"last_announce,omitempty"`
+	NextAnnounce string `json:"next_announce,omitempty"`
+}
+
+var (
+	Trackers      []string
+	trackerSource = TrackerSource{
+		URL:   "https://raw.githubusercontent.com/ngosang/trackerslist/master/trackers_all.txt",
+		Hours: 24,
+	}
+	trackersMutex   sync.RWMutex
+	trackersRefresh = make(chan struct{}, 1)
+	// Torrents from magnets with their own trackers only get the public
+	// ones once their metadata shows they are not private.
+	pendingTrackers = make(map[string]bool)
+)
+
+func InitTrackers() {
+	trackersMutex.Lock()
+	if err := loadState(trackersStateFile, &trackerSource); err != nil {
+		log.Printf("Could not load tracker settings: %v", err)
+	}
+	trackersMutex.Unlock()
+	GetTrakers()
+	go refreshTrackers()
+}
+
+func refreshTrackers() {
+	for {
+		trackersMutex.RLock()
+		interval := time.Duration(trackerSource.Hours * float64(time.Hour))
+		trackersMutex.RUnlock()
+		if interval <= 0 {
+			interval = 24 * time.Hour
+		}
+		select {
+		case <-time.After(interval):
+		case <-trackersRefresh:
+		}
+		GetTrakers()
+	}
+}
+
+func parseTrackerList(data string) []string {
+	var list []string
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if u, err := url.Parse(line); err == nil && (u.Scheme == "udp" || u.Scheme == "http" || u.Scheme == "https") {
+			list = append(list, line)
+		}
+	}
+	return list
+}
+
+// GetTrakers reloads the public tracker list from the configured URL,
+// falling back to the last fetched copy when the URL is unreachable.
+func GetTrakers() {
+	trackersMutex.RLock()
+	src := trackerSource
+	trackersMutex.RUnlock()
+
+	var list []string
+	if src.URL != "" {
+		if data, err := fetchTrackerList(src.URL); err != nil {
+			log.Printf("Could not fetch tracker list: %v", err)
+		} else {
+			list = parseTrackerList(data)
+			if len(list) > 0 {
+				os.MkdirAll(StateDir, 0755)
+				if err := ioutil.WriteFile(trackersCacheFile(), []byte(strings.Join(list, "\n")), 0644); err != nil {
+					log.Printf("Could not cache tracker list: %v", err)
+				}
+			}
+		}
+	}
+	if len(list) == 0 {
+		if data, err := ioutil.ReadFile(trackersCacheFile()); err == nil {
+			list = parseTrackerList(string(data))
+		}
+	}
+	trackersMutex.Lock()
+	if len(list) > 0 || src.URL == "" {
+		Trackers = list
+	}
+	trackersMutex.Unlock()
+}
+
+func fetchTrackerList(uri string) (string, error) {
+	resp, err := hClient.Get(uri)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("%s", resp.Status)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	return string(data), err
+}
+
+func GetTrackerSource() TrackerSource {
+	trackersMutex.RLock()
+	defer trackersMutex.RUnlock()
+	return trackerSource
+}
+
+func SetTrackerSource(src TrackerSource) error {
+	if src.URL != "" {
+		if u, err := url.Parse(src.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("invalid tracker list url")
+		}
+	}
+	if src.Hours < 0 {
+		return fmt.Errorf("refresh interval must not be negative")
+	}
+	trackersMutex.Lock()
+	trackerSource = src
+	if err := saveState(trackersStateFile, &trackerSource); err != nil {
+		log.Printf("Could not save tracker settings: %v", err)
+	}
+	trackersMutex.Unlock()
+	select {
+	case trackersRefresh <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// addPublicTrackers adds the public tracker list to a new torrent unless
+// it is private. Magnets without trackers of their own can not be private
+// trackers' torrents and get them right away.
+func addPublicTrackers(t *torrent.Torrent, source string) {
+	if meta, err := TorrentMetainfo(t); err == nil {
+		if !meta.Private {
+			addTrackers(t)
+		}
+		return
+	}
+	if strings.HasPrefix(source, "magnet:") && !strings.Contains(source, "tr=") {
+		addTrackers(t)
+		return
+	}
+	trackersMutex.Lock()
+	pendingTrackers[t.ID()] = true
+	trackersMutex.Unlock()
+}
+
+func applyPendingTrackers() {
+	trackersMutex.Lock()
+	var ready []*torrent.Torrent
+	for id := range pendingTrackers {
+		t := GetSession().GetTorrent(id)
+		if t == nil {
+			delete(pendingTrackers, id)
+			continue
+		}
+		if meta, err := TorrentMetainfo(t); err == nil {
+			delete(pendingTrackers, id)
+			if !meta.Private {
+				ready = append(ready, t)
+			}
+		}
+	}
+	trackersMutex.Unlock()
+	for _, t := range ready {
+		addTrackers(t)
+	}
+}
+
+func addTrackers(t *torrent.Torrent) {
+	trackersMutex.RLock()
+	defer trackersMutex.RUnlock()
+	for i := range Trackers {
+		t.AddTracker(Trackers[i])
+	}
+}
+
+func GetTorrentTrackers(id string) ([]TrackerInfo, error) {
+	t := GetSession().GetTorrent(id)
+	if t == nil {
+		return nil, ErrTorrentNotFound
+	}
+	list := []TrackerInfo{}
+	for _, tr := range t.Trackers() {
+		info := TrackerInfo{
+			URL:          tr.URL,
+			Status:       fmt.Sprint(tr.Status),
+			Seeders:      tr.Seeders,
+			Leechers:     tr.Leechers,
+			Warning:      tr.Warning,
+			LastAnnounce: formatTime(tr.LastAnnounce),
+			NextAnnounce: formatTime(tr.NextAnnounce),
+		}
+		if tr.Error != nil {
+			info.Error = tr.Error.Message
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+func AddTorrentTracker(id, uri string) error {
+	t := GetSession().GetTorrent(id)
+	if t == nil {
+		return ErrTorrentNotFound
+	}
+	return t.AddTracker(uri)
+}
+
+func ReannounceTorrent(id string) error {
+	t := GetSession().GetTorrent(id)
+	if t == nil {
+		return ErrTorrentNotFound
+	}
+	t.Announce()
+	return nil
+}
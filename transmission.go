@@ -0,0 +1,540 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/rain/torrent"
+	"github.com/gin-gonic/gin"
+)
+
+// The Transmission RPC protocol, enough of it for Sonarr, Radarr and
+// transmission-remote. Torrent ids are the stable numbers shown as
+// TorrentData.ID, and download directories map onto categories.
+
+var (
+	transmissionSessionID = func() string {
+		b := make([]byte, 24)
+		rand.Read(b)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}()
+	transmissionStarted = time.Now()
+)
+
+type transmissionRequest struct {
+	Method    string          `json:"method"`
+	Arguments json.RawMessage `json:"arguments"`
+	Tag       interface{}     `json:"tag,omitempty"`
+}
+
+type transmissionArgs map[string]interface{}
+
+func TransmissionRPCHandler(c *gin.Context) {
+	if c.GetHeader("X-Transmission-Session-Id") != transmissionSessionID {
+		c.Header("X-Transmission-Session-Id", transmissionSessionID)
+		c.String(http.StatusConflict, "<h1>409: Conflict</h1><p>Your request had an invalid session-id header.</p><p><code>X-Transmission-Session-Id: %s</code></p>", transmissionSessionID)
+		return
+	}
+	var req transmissionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, err.Error())
+		return
+	}
+	args, err := transmissionCall(req.Method, req.Arguments)
+	result := "success"
+	if err != nil {
+		result = err.Error()
+	}
+	if args == nil {
+		args = transmissionArgs{}
+	}
+	c.JSON(http.StatusOK, gin.H{"result": result, "arguments": args, "tag": req.Tag})
+}
+
+func transmissionCall(method string, raw json.RawMessage) (transmissionArgs, error) {
+	var args struct {
+		IDs             json.RawMessage `json:"ids"`
+		Fields          []string        `json:"fields"`
+		Filename        string          `json:"filename"`
+		Metainfo        string          `json:"metainfo"`
+		DownloadDir     string          `json:"download-dir"`
+		Paused          bool            `json:"paused"`
+		Labels          []string        `json:"labels"`
+		DeleteLocalData bool            `json:"delete-local-data"`
+		SeedRatioLimit  *float64        `json:"seedRatioLimit"`
+		SeedRatioMode   *int            `json:"seedRatioMode"`
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &args); err != nil {
+			return nil, err
+		}
+	}
+	switch method {
+	case "session-get":
+		return transmissionSession(), nil
+	case "session-set":
+		var settings map[string]interface{}
+		json.Unmarshal(raw, &settings)
+		return nil, transmissionSessionSet(settings)
+	case "session-stats":
+		return transmissionStats(), nil
+	case "torrent-add":
+		return transmissionAdd(args.Filename, args.Metainfo, args.DownloadDir, args.Paused, args.Labels)
+	case "torrent-get":
+		list := []map[string]interface{}{}
+		for _, t := range transmissionTorrents(args.IDs) {
+			list = append(list, transmissionFields(t, args.Fields))
+		}
+		return transmissionArgs{"torrents": list}, nil
+	case "torrent-set":
+		for _, t := range transmissionTorrents(args.IDs) {
+			if args.Labels != nil {
+				label := strings.Join(args.Labels, ",")
+				SetMetaInfo(t.ID(), &label, nil)
+			}
+			if args.SeedRatioMode != nil || args.SeedRatioLimit != nil {
+				if err := transmissionSeedRatio(t.ID(), args.SeedRatioMode, args.SeedRatioLimit); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return nil, nil
+	case "torrent-start", "torrent-start-now":
+		for _, t := range transmissionTorrents(args.IDs) {
+			ResumeTorrentByID(t.ID())
+		}
+		return nil, nil
+	case "torrent-stop":
+		for _, t := range transmissionTorrents(args.IDs) {
+			if _, err := PauseTorrentByID(t.ID()); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case "torrent-verify":
+		for _, t := range transmissionTorrents(args.IDs) {
+			if err := RecheckTorrent(t.ID()); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case "torrent-reannounce":
+		for _, t := range transmissionTorrents(args.IDs) {
+			t.Announce()
+		}
+		return nil, nil
+	case "torrent-remove":
+		for _, t := range transmissionTorrents(args.IDs) {
+			if _, err := RemoveTorrent(t.ID(), args.DeleteLocalData); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+	return nil, fmt.Errorf("method name not recognized")
+}
+
+// transmissionTorrents resolves the ids argument, which is absent for all
+// torrents, a single id, "recently-active", or a list of ids and hashes.
+func transmissionTorrents(raw json.RawMessage) []*torrent.Torrent {
+	all := GetTorrents()
+	var ids []interface{}
+	var single interface{}
+	if len(raw) == 0 {
+		return all
+	} else if err := json.Unmarshal(raw, &ids); err != nil {
+		if json.Unmarshal(raw, &single) != nil || single == "recently-active" {
+			return all
+		}
+		ids = []interface{}{single}
+	}
+	var list []*torrent.Torrent
+	for _, t := range all {
+		seq := float64(GetMeta(t.ID()).Seq)
+		hash := t.InfoHash().String()
+		for _, id := range ids {
+			if n, ok := id.(float64); ok && n == seq {
+				list = append(list, t)
+				break
+			}
+			if s, ok := id.(string); ok && strings.EqualFold(s, hash) {
+				list = append(list, t)
+				break
+			}
+		}
+	}
+	return list
+}
+
+func transmissionAdd(filename, metainfo, dir string, paused bool, labels []string) (transmissionArgs, error) {
+	opts := &AddOptions{Category: CategoryForDir(dir), Label: strings.Join(labels, ","), Paused: paused}
+	var data []byte
+	if metainfo != "" {
+		var err error
+		if data, err = base64.StdEncoding.DecodeString(metainfo); err != nil {
+			return nil, fmt.Errorf("invalid metainfo")
+		}
+	}
+	t, added, err := AddTorrentSource(filename, data, opts)
+	if err != nil {
+		return nil, err
+	}
+	info := map[string]interface{}{
+		"id":         GetMeta(t.ID()).Seq,
+		"name":       t.Name(),
+		"hashString": t.InfoHash().String(),
+	}
+	if !added {
+		return transmissionArgs{"torrent-duplicate": info}, nil
+	}
+	BroadcastMessage("torrent_added", map[string]string{"status": "ok"})
+	return transmissionArgs{"torrent-added": info}, nil
+}
+
+// Transmission torrent states.
+const (
+	trStopped = iota
+	trCheckWait
+	trCheck
+	trDownloadWait
+	trDownload
+	trSeedWait
+	trSeed
+)
+
+// transmissionStatus maps a rain status to a Transmission one. Torrents
+// waiting for a queue slot are reported as waiting to download or seed.
+func transmissionStatus(status torrent.Status, complete, waiting bool) int {
+	switch status {
+	case torrent.Stopped:
+		if waiting && complete {
+			return trSeedWait
+		} else if waiting {
+			return trDownloadWait
+		}
+	case torrent.Allocating, torrent.Verifying:
+		return trCheck
+	case torrent.DownloadingMetadata, torrent.Downloading:
+		return trDownload
+	case torrent.Seeding:
+		return trSeed
+	}
+	return trStopped
+}
+
+func transmissionFields(t *torrent.Torrent, fields []string) map[string]interface{} {
+	stats := t.Stats()
+	meta := GetMeta(t.ID())
+	complete := stats.Pieces.Total > 0 && stats.Pieces.Have == stats.Pieces.Total
+	status := transmissionStatus(stats.Status, complete, stats.Status == torrent.Stopped && QueueWaiting(t))
+	eta := int64(-1)
+	if stats.ETA != nil {
+		eta = int64(stats.ETA.Seconds())
+	}
+	percent := 0.0
+	if stats.Pieces.Total > 0 {
+		percent = float64(stats.Pieces.Have) / float64(stats.Pieces.Total)
+	}
+	errCode, errString := 0, ""
+	if stats.Error != nil {
+		errCode, errString = 3, stats.Error.Error()
+	}
+	labels := []string{}
+	if meta.Label != "" {
+		labels = SplitList(meta.Label)
+	}
+	policy := GetSeedPolicy(t.ID())
+	ratioMode, ratioLimit := 2, 0.0
+	if policy.Mode == SeedRatio {
+		ratioMode, ratioLimit = 1, policy.Ratio
+	}
+	var doneDate int64
+	if !meta.CompletedAt.IsZero() {
+		doneDate = meta.CompletedAt.Unix()
+	}
+	metadata := 1.0
+	if stats.Status == torrent.DownloadingMetadata {
+		metadata = 0
+	}
+	magnet, _ := t.Magnet()
+	f := map[string]interface{}{
+		"id":                      meta.Seq,
+		"hashString":              t.InfoHash().String(),
+		"name":                    t.Name(),
+		"status":                  status,
+		"error":                   errCode,
+		"errorString":             errString,
+		"downloadDir":             TorrentDataDir(t.ID()),
+		"totalSize":               stats.Bytes.Total,
+		"sizeWhenDone":            stats.Bytes.Total,
+		"leftUntilDone":           stats.Bytes.Incomplete,
+		"haveValid":               stats.Bytes.Completed,
+		"percentDone":             percent,
+		"metadataPercentComplete": metadata,
+		"rateDownload":            stats.Speed.Download,
+		"rateUpload":              stats.Speed.Upload,
+		"eta":                     eta,
+		"uploadRatio":             GetRatio(t),
+		"downloadedEver":          stats.Bytes.Downloaded,
+		"uploadedEver":            stats.Bytes.Uploaded,
+		"peersConnected":          stats.Peers.Total,
+		"addedDate":               meta.AddedAt.Unix(),
+		"doneDate":                doneDate,
+		"secondsSeeding":          int64(stats.SeededFor.Seconds()),
+		"isFinished":              complete && stats.Status == torrent.Stopped && seedLimitReached(t, policy),
+		"isPrivate":               stats.Private,
+		"labels":                  labels,
+		"magnetLink":              magnet,
+		"queuePosition":           QueuePosition(t.ID()),
+		"seedRatioMode":           ratioMode,
+		"seedRatioLimit":          ratioLimit,
+		"seedIdleMode":            0,
+		"seedIdleLimit":           0,
+	}
+	if wantField(fields, "files") || wantField(fields, "fileStats") || wantField(fields, "fileCount") {
+		files, fileStats := []interface{}{}, []interface{}{}
+		if list, err := GetTorrentFiles(t.ID()); err == nil {
+			all, _ := t.FileStats()
+			for i, tf := range list {
+				files = append(files, map[string]interface{}{"name": tf.Path, "length": all[i].File.Length(), "bytesCompleted": all[i].BytesCompleted})
+				fileStats = append(fileStats, map[string]interface{}{"bytesCompleted": all[i].BytesCompleted, "wanted": true, "priority": 0})
+			}
+		}
+		f["files"], f["fileStats"], f["fileCount"] = files, fileStats, len(files)
+	}
+	if wantField(fields, "trackers") || wantField(fields, "trackerStats") {
+		trackers, trackerStats := []interface{}{}, []interface{}{}
+		for i, tr := range t.Trackers() {
+			trackers = append(trackers, map[string]interface{}{"id": i, "announce": tr.URL, "tier": i})
+			var lastAnnounce, nextAnnounce int64
+			if !tr.LastAnnounce.IsZero() {
+				lastAnnounce = tr.LastAnnounce.Unix()
+			}
+			if !tr.NextAnnounce.IsZero() {
+				nextAnnounce = tr.NextAnnounce.Unix()
+			}
+			result := "Success"
+			if tr.Error != nil {
+				result = tr.Error.Message
+			}
+			trackerStats = append(trackerStats, map[string]interface{}{
+				"id":                    i,
+				"announce":              tr.URL,
+				"tier":                  i,
+				"seederCount":           tr.Seeders,
+				"leecherCount":          tr.Leechers,
+				"lastAnnounceTime":      lastAnnounce,
+				"lastAnnounceSucceeded": lastAnnounce > 0 && tr.Error == nil,
+				"lastAnnounceResult":    result,
+				"nextAnnounceTime":      nextAnnounce,
+			})
+		}
+		f["trackers"], f["trackerStats"] = trackers, trackerStats
+	}
+	if len(fields) == 0 {
+		return f
+	}
+	selected := make(map[string]interface{})
+	for _, name := range fields {
+		if v, ok := f[name]; ok {
+			selected[name] = v
+		}
+	}
+	return selected
+}
+
+func wantField(fields []string, name string) bool {
+	return len(fields) == 0 || StringInSlice(name, fields)
+}
+
+// transmissionDefaultRatio is Transmission's own default seed ratio, used
+// when a ratio limit is switched on without a ratio.
+const transmissionDefaultRatio = 2.0
+
+// transmissionSeedRatio maps a seed ratio mode, 0 global, 1 per torrent or
+// 2 unlimited, onto a seeding policy.
+func transmissionSeedRatio(id string, mode *int, limit *float64) error {
+	m := 1
+	if mode != nil {
+		m = *mode
+	}
+	switch m {
+	case 0:
+		return SetSeedPolicy(id, nil)
+	case 2:
+		return SetSeedPolicy(id, &SeedPolicy{Mode: SeedForever})
+	}
+	p := GetSeedPolicy(id)
+	if limit != nil {
+		p.Ratio = *limit
+	}
+	if p.Ratio <= 0 {
+		p.Ratio = transmissionDefaultRatio
+	}
+	return SetSeedPolicy(id, &SeedPolicy{Mode: SeedRatio, Ratio: p.Ratio})
+}
+
+func transmissionSession() transmissionArgs {
+	bandwidthMutex.RLock()
+	global := bandwidthState.Global
+	bandwidthMutex.RUnlock()
+	queue := GetQueueConfig()
+	seedMutex.RLock()
+	seed := seedState.Global
+	seedMutex.RUnlock()
+	settings := GetSessionSettings()
+	encryption := settings.Encryption
+	if encryption == EncryptionDisabled {
+		encryption = "tolerated"
+	}
+	return transmissionArgs{
+		"version":                    "3.00 (cloud-torrent)",
+		"rpc-version":                17,
+		"rpc-version-minimum":        14,
+		"session-id":                 transmissionSessionID,
+		"download-dir":               Root,
+		"incomplete-dir-enabled":     false,
+		"speed-limit-down":           global.Download,
+		"speed-limit-down-enabled":   global.Download > 0,
+		"speed-limit-up":             global.Upload,
+		"speed-limit-up-enabled":     global.Upload > 0,
+		"alt-speed-enabled":          false,
+		"download-queue-size":        queue.MaxDownloads,
+		"download-queue-enabled":     queue.MaxDownloads > 0,
+		"seed-queue-size":            queue.MaxSeeds,
+		"seed-queue-enabled":         queue.MaxSeeds > 0,
+		"seedRatioLimit":             seed.Ratio,
+		"seedRatioLimited":           seed.Mode == SeedRatio,
+		"idle-seeding-limit":         0,
+		"idle-seeding-limit-enabled": false,
+		"peer-port":                  settings.PortBegin,
+		"peer-limit-per-torrent":     settings.MaxPeerDial + settings.MaxPeerAccept,
+		"dht-enabled":                settings.DHT,
+		"pex-enabled":                settings.PEX,
+		"encryption":                 encryption,
+	}
+}
+
+func transmissionSessionSet(s map[string]interface{}) error {
+	number := func(key string, v *int64) {
+		if n, ok := s[key].(float64); ok {
+			*v = int64(n)
+		}
+	}
+	enabled := func(key string, v *int64) {
+		if on, ok := s[key].(bool); ok && !on {
+			*v = 0
+		}
+	}
+	settings := GetSessionSettings()
+	if n, ok := s["peer-port"].(float64); ok {
+		if n < 1 || n+float64(settings.PortEnd-settings.PortBegin) > 65535 {
+			return fmt.Errorf("invalid peer-port %v", n)
+		}
+		settings.PortEnd = uint16(n) + settings.PortEnd - settings.PortBegin
+		settings.PortBegin = uint16(n)
+	}
+	if on, ok := s["dht-enabled"].(bool); ok {
+		settings.DHT = on
+	}
+	if on, ok := s["pex-enabled"].(bool); ok {
+		settings.PEX = on
+	}
+	if mode, ok := s["encryption"].(string); ok {
+		switch mode {
+		case "required":
+			settings.Encryption = EncryptionRequired
+		case "preferred":
+			settings.Encryption = EncryptionPreferred
+		case "tolerated":
+			settings.Encryption = EncryptionDisabled
+		default:
+			return fmt.Errorf("invalid encryption %q", mode)
+		}
+	}
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	bandwidthMutex.RLock()
+	limit := bandwidthState.Global
+	bandwidthMutex.RUnlock()
+	number("speed-limit-down", &limit.Download)
+	enabled("speed-limit-down-enabled", &limit.Download)
+	number("speed-limit-up", &limit.Upload)
+	enabled("speed-limit-up-enabled", &limit.Upload)
+	if err := SetGlobalSpeedLimit(limit); err != nil {
+		return err
+	}
+
+	queue := GetQueueConfig()
+	downloads, seeds := int64(queue.MaxDownloads), int64(queue.MaxSeeds)
+	number("download-queue-size", &downloads)
+	enabled("download-queue-enabled", &downloads)
+	number("seed-queue-size", &seeds)
+	enabled("seed-queue-enabled", &seeds)
+	if err := SetQueueConfig(QueueConfig{MaxDownloads: int(downloads), MaxSeeds: int(seeds)}); err != nil {
+		return err
+	}
+
+	_, hasLimit := s["seedRatioLimit"]
+	limited, hasLimited := s["seedRatioLimited"].(bool)
+	if hasLimit || hasLimited {
+		seedMutex.RLock()
+		p := seedState.Global
+		seedMutex.RUnlock()
+		if n, ok := s["seedRatioLimit"].(float64); ok {
+			p.Ratio = n
+		}
+		if hasLimited {
+			p.Mode = SeedForever
+			if limited {
+				p.Mode = SeedRatio
+			}
+		}
+		if p.Mode == SeedRatio && p.Ratio <= 0 {
+			p.Ratio = transmissionDefaultRatio
+		}
+		if err := SetGlobalSeedPolicy(p); err != nil {
+			return err
+		}
+	}
+	return SetSessionSettings(settings)
+}
+
+func transmissionStats() transmissionArgs {
+	var active, paused, down, up int
+	var downloaded, uploaded int64
+	for _, t := range GetTorrents() {
+		stats := t.Stats()
+		if stats.Status == torrent.Stopped {
+			paused++
+		} else {
+			active++
+		}
+		down += stats.Speed.Download
+		up += stats.Speed.Upload
+		downloaded += stats.Bytes.Downloaded
+		uploaded += stats.Bytes.Uploaded
+	}
+	current := map[string]interface{}{
+		"downloadedBytes": downloaded,
+		"uploadedBytes":   uploaded,
+		"secondsActive":   int64(time.Since(transmissionStarted).Seconds()),
+		"sessionCount":    1,
+	}
+	return transmissionArgs{
+		"activeTorrentCount": active,
+		"pausedTorrentCount": paused,
+		"torrentCount":       active + paused,
+		"downloadSpeed":      down,
+		"uploadSpeed":        up,
+		"current-stats":      current,
+		"cumulative-stats":   current,
+	}
+}
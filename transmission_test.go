@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/cenkalti/rain/torrent"
+)
+
+func TestTransmissionStatus(t *testing.T) {
+	for _, c := range []struct {
+		status            torrent.Status
+		complete, waiting bool
+		want              int
+	}{
+		{torrent.Stopped, false, false, trStopped},
+		{torrent.Stopped, true, false, trStopped},
+		{torrent.Stopped, false, true, trDownloadWait},
+		{torrent.Stopped, true, true, trSeedWait},
+		{torrent.Allocating, false, false, trCheck},
+		{torrent.Verifying, true, false, trCheck},
+		{torrent.DownloadingMetadata, false, false, trDownload},
+		{torrent.Downloading, false, false, trDownload},
+		{torrent.Seeding, true, false, trSeed},
+	} {
+		if got := transmissionStatus(c.status, c.complete, c.waiting); got != c.want {
+			t.Errorf("transmissionStatus(%d, %v, %v) = %d, want %d", c.status, c.complete, c.waiting, got, c.want)
+		}
+	}
+}
+
+func TestWantField(t *testing.T) {
+	if !wantField(nil, "name") {
+		t.Error("no fields should select every field")
+	}
+	if !wantField([]string{"id", "name"}, "name") || wantField([]string{"id"}, "name") {
+		t.Error("listed fields not honoured")
+	}
+}
+
+func TestTransmissionCallRejects(t *testing.T) {
+	if _, err := transmissionCall("torrent-frobnicate", nil); err == nil {
+		t.Error("unknown method accepted")
+	}
+	if _, err := transmissionCall("torrent-add", json.RawMessage(`{"ids": {}`)); err == nil {
+		t.Error("malformed arguments accepted")
+	}
+	if _, err := transmissionCall("torrent-add", json.RawMessage(`{"metainfo": "not base64!"}`)); err == nil || err.Error() != "invalid metainfo" {
+		t.Errorf("invalid metainfo: %v", err)
+	}
+}
+
+func TestTransmissionSessionSetRejects(t *testing.T) {
+	for _, args := range []string{
+		`{"peer-port": 0}`,
+		`{"peer-port": 70000}`,
+		`{"encryption": "sometimes"}`,
+	} {
+		var s map[string]interface{}
+		json.Unmarshal([]byte(args), &s)
+		if err := transmissionSessionSet(s); err == nil {
+			t.Errorf("%s: expected an error", args)
+		}
+	}
+}
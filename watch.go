@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const watchStateFile = "watch.json"
+
+// watchSettle is how long a file must stay unmodified before it is picked
+// up, so files still being written are left alone.
+const watchSettle = 2 * time.Second
+
+// WatchConfig is the directory polled for .torrent and .magnet files. Files
+// in a subfolder are added to the category of the same name. An empty Dir
+// disables the watcher.
+type WatchConfig struct {
+	Dir     string `json:"dir"`
+	Seconds int    `json:"seconds"`
+}
+
+var (
+	watchConfig = WatchConfig{Seconds: 10}
+	watchMutex  sync.RWMutex
+	watchWake   = make(chan struct{}, 1)
+)
+
+func InitWatch() {
+	watchMutex.Lock()
+	if err := loadState(watchStateFile, &watchConfig); err != nil {
+		log.Printf("Could not load watch folder settings: %v", err)
+	}
+	watchMutex.Unlock()
+	go watchFolder()
+}
+
+func GetWatchConfig() WatchConfig {
+	watchMutex.RLock()
+	defer watchMutex.RUnlock()
+	return watchConfig
+}
+
+func SetWatchConfig(cfg WatchConfig) error {
+	if cfg.Seconds < 1 {
+		return fmt.Errorf("interval must be at least one second")
+	}
+	if cfg.Dir != "" {
+		if !filepath.IsAbs(cfg.Dir) {
+			return fmt.Errorf("watch directory must be an absolute path")
+		}
+		cfg.Dir = filepath.Clean(cfg.Dir)
+		if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+			return err
+		}
+	}
+	watchMutex.Lock()
+	watchConfig = cfg
+	if err := saveState(watchStateFile, &watchConfig); err != nil {
+		log.Printf("Could not save watch folder settings: %v", err)
+	}
+	watchMutex.Unlock()
+	select {
+	case watchWake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func watchFolder() {
+	for {
+		cfg := GetWatchConfig()
+		if cfg.Dir != "" {
+			scanWatchDir(cfg.Dir, "")
+			if entries, err := ioutil.ReadDir(cfg.Dir); err == nil {
+				for _, e := range entries {
+					if e.IsDir() && e.Name() != "added" && e.Name() != "failed" {
+						scanWatchDir(filepath.Join(cfg.Dir, e.Name()), e.Name())
+					}
+				}
+			}
+		}
+		select {
+		case <-time.After(time.Duration(cfg.Seconds) * time.Second):
+		case <-watchWake:
+		}
+	}
+}
+
+// scanWatchDir adds every settled torrent or magnet file in dir and moves
+// it into the added or failed subfolder next to it.
+func scanWatchDir(dir, category string) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		log.Printf("Could not read watch folder: %v", err)
+		return
+	}
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if !e.Mode().IsRegular() || (ext != ".torrent" && ext != ".magnet") || time.Since(e.ModTime()) < watchSettle {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		err := addWatchedFile(path, ext, category)
+		result := "added"
+		if err != nil {
+			result = "failed"
+			log.Printf("Could not add %s from watch folder: %v", path, err)
+		} else {
+			BroadcastMessage("torrent_added", map[string]string{"status": "ok"})
+		}
+		mvErr := os.MkdirAll(filepath.Join(dir, result), 0755)
+		if mvErr == nil {
+			mvErr = os.Rename(path, filepath.Join(dir, result, e.Name()))
+		}
+		if mvErr != nil {
+			log.Printf("Could not move %s to %s: %v", path, result, mvErr)
+		}
+	}
+}
+
+func addWatchedFile(path, ext, category string) error {
+	opts := &AddOptions{Category: category}
+	if category != "" {
+		if _, ok := GetCategory(category); !ok {
+			return fmt.Errorf("category %q not found", category)
+		}
+	}
+	if ext == ".magnet" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		magnet := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(magnet, "magnet:") {
+			return fmt.Errorf("not a magnet link")
+		}
+		_, err = AddTorrentByMagnet(magnet, opts)
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Size() > maxTorrentSize {
+		return fmt.Errorf("torrent file too large")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	opts.Source = "watch:" + filepath.Base(path)
+	_, err = AddTorrentByFile(data, opts)
+	return err
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const webhooksStateFile = "webhooks.json"
+
+const (
+	webhookLogSize  = 200
+	webhookAttempts = 6
+	webhookBackoff  = 5 * time.Second
+)
+
+// Webhook receives a signed JSON POST for each event it subscribes to, or
+// for every event when Events is empty. With a Secret, the body is signed
+// with HMAC-SHA256 in the X-CloudTorrent-Signature header.
+type Webhook struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	URL      string   `json:"url"`
+	Secret   string   `json:"secret,omitempty"`
+	Events   []string `json:"events,omitempty"`
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+// WebhookInfo is a webhook as shown by the API, without its secret.
+type WebhookInfo struct {
+	Webhook
+	HasSecret bool `json:"has_secret"`
+}
+
+func (w Webhook) info() WebhookInfo {
+	i := WebhookInfo{Webhook: w, HasSecret: w.Secret != ""}
+	i.Secret = ""
+	return i
+}
+
+type WebhookDelivery struct {
+	ID          string    `json:"id"`
+	Webhook     string    `json:"webhook"`
+	Event       string    `json:"event"`
+	Status      string    `json:"status"`
+	Attempts    int       `json:"attempts"`
+	Code        int       `json:"code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastAttempt time.Time `json:"last_attempt,omitempty"`
+}
+
+var (
+	webhooksState = struct {
+		Webhooks   []Webhook          `json:"webhooks"`
+		Deliveries []*WebhookDelivery `json:"deliveries"`
+	}{}
+	webhooksMutex sync.Mutex
+	webhookClient = &http.Client{Timeout: 15 * time.Second}
+)
+
+func InitWebhooks() {
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+	if err := loadState(webhooksStateFile, &webhooksState); err != nil {
+		log.Printf("Could not load webhooks: %v", err)
+	}
+	// Retries are not resumed, deliveries cut short by a restart failed
+	interrupted := false
+	for _, d := range webhooksState.Deliveries {
+		if d.Status == "pending" || d.Status == "retrying" {
+			d.Status, d.Error = "failed", "interrupted by a restart"
+			interrupted = true
+		}
+	}
+	if interrupted {
+		saveWebhooksState()
+	}
+}
+
+func saveWebhooksState() {
+	if err := saveState(webhooksStateFile, &webhooksState); err != nil {
+		log.Printf("Could not save webhooks: %v", err)
+	}
+}
+
+func GetWebhooks() []Webhook {
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+	return append([]Webhook{}, webhooksState.Webhooks...)
+}
+
+// SetWebhook creates a webhook, or replaces an existing one when its ID is
+// set. An update without a secret keeps the old one unless clearSecret is
+// set.
+func SetWebhook(w Webhook, clearSecret bool) (Webhook, error) {
+	if u, err := url.Parse(w.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return w, fmt.Errorf("invalid webhook url")
+	}
+	for _, ev := range w.Events {
+		if !StringInSlice(ev, hookEvents) {
+			return w, fmt.Errorf("invalid event %q", ev)
+		}
+	}
+	if w.Name == "" {
+		w.Name = w.URL
+	}
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+	if w.ID == "" {
+		b := make([]byte, 8)
+		rand.Read(b)
+		w.ID = hex.EncodeToString(b)
+		webhooksState.Webhooks = append(webhooksState.Webhooks, w)
+	} else {
+		found := false
+		for i := range webhooksState.Webhooks {
+			if webhooksState.Webhooks[i].ID == w.ID {
+				if w.Secret == "" && !clearSecret {
+					w.Secret = webhooksState.Webhooks[i].Secret
+				}
+				webhooksState.Webhooks[i] = w
+				found = true
+			}
+		}
+		if !found {
+			return w, fmt.Errorf("webhook not found")
+		}
+	}
+	saveWebhooksState()
+	return w, nil
+}
+
+func RemoveWebhook(id string) error {
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+	for i, w := range webhooksState.Webhooks {
+		if w.ID == id {
+			webhooksState.Webhooks = append(webhooksState.Webhooks[:i], webhooksState.Webhooks[i+1:]...)
+			saveWebhooksState()
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook not found")
+}
+
+// GetWebhookDeliveries returns the delivery log, newest first.
+func GetWebhookDeliveries() []WebhookDelivery {
+	webhooksMutex.Lock()
+	defer webhooksMutex.Unlock()
+	list := make([]WebhookDelivery, 0, len(webhooksState.Deliveries))
+	for i := len(webhooksState.Deliveries) - 1; i >= 0; i-- {
+		list = append(list, *webhooksState.Deliveries[i])
+	}
+	return list
+}
+
+// TestWebhook sends a ping event to a webhook.
+func TestWebhook(id string) error {
+	for _, w := range GetWebhooks() {
+		if w.ID == id {
+			go deliverWebhook(w, HookEvent{Event: "ping", Name: "test"})
+			return nil
+		}
+	}
+	return fmt.Errorf("webhook not found")
+}
+
+func fireWebhooks(ev HookEvent) {
+	for _, w := range GetWebhooks() {
+		if w.Disabled || (len(w.Events) > 0 && !StringInSlice(ev.Event, w.Events)) {
+			continue
+		}
+		go deliverWebhook(w, ev)
+	}
+}
+
+// deliverWebhook posts an event, retrying with exponential backoff on
+// network errors and non-2xx responses.
+func deliverWebhook(w Webhook, ev HookEvent) {
+	b := make([]byte, 8)
+	rand.Read(b)
+	d := &WebhookDelivery{ID: hex.EncodeToString(b), Webhook: w.Name, Event: ev.Event, Status: "pending", CreatedAt: time.Now()}
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    d.ID,
+		"event": ev.Event,
+		"time":  d.CreatedAt.Format(time.RFC3339),
+		"data":  ev,
+	})
+	webhooksMutex.Lock()
+	webhooksState.Deliveries = append(webhooksState.Deliveries, d)
+	if len(webhooksState.Deliveries) > webhookLogSize {
+		webhooksState.Deliveries = webhooksState.Deliveries[len(webhooksState.Deliveries)-webhookLogSize:]
+	}
+	webhooksMutex.Unlock()
+
+	delay := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		code, err := postWebhook(w, d.ID, ev.Event, body)
+		webhooksMutex.Lock()
+		d.Attempts, d.Code, d.LastAttempt, d.Error = attempt, code, time.Now(), ""
+		if err != nil {
+			d.Error = err.Error()
+			d.Status = "retrying"
+			if attempt == webhookAttempts {
+				d.Status = "failed"
+			}
+		} else {
+			d.Status = "delivered"
+		}
+		saveWebhooksState()
+		webhooksMutex.Unlock()
+		if err == nil {
+			return
+		}
+		if attempt < webhookAttempts {
+			time.Sleep(delay)
+			delay *= 2
+		}
+	}
+	log.Printf("Could not deliver %s to webhook %s: %s", ev.Event, w.Name, d.Error)
+}
+
+func postWebhook(w Webhook, delivery, event string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-CloudTorrent-Event", event)
+	req.Header.Set("X-CloudTorrent-Delivery", delivery)
+	if w.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.Secret))
+		mac.Write(body)
+		req.Header.Set("X-CloudTorrent-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"sync"
@@ -16,7 +17,8 @@ var (
 			return true
 		},
 	}
-	wsClients   = make(map[*websocket.Conn]bool)
+	wsClients   = make(map[*websocket.Conn]*wsClient)
+	wsWatching  = make(map[*websocket.Conn]string)
 	wsClientsMu sync.RWMutex
 	wsBroadcast = make(chan WSMessage, 100)
 )
@@ -24,6 +26,54 @@ var (
 type WSMessage struct {
 	Type string      `json:"type"`
 	Data interface{} `json:"data"`
+	// to limits the message to a single client
+	to *websocket.Conn
+}
+
+// wsSendBuffer is how many messages may wait for a slow client before it
+// is disconnected.
+const wsSendBuffer = 64
+
+// wsClient queues the messages of one connection, only its writer
+// goroutine writes to conn.
+type wsClient struct {
+	conn *websocket.Conn
+	send chan []byte
+}
+
+func (c *wsClient) writeLoop() {
+	for data := range c.send {
+		if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
+			// Closing the connection ends the read loop, which closes send
+			c.conn.Close()
+			for range c.send {
+			}
+			return
+		}
+	}
+}
+
+// queue hands data to the writer of a connected client, a client that
+// does not keep up is disconnected. wsClientsMu must be held.
+func (c *wsClient) queue(data []byte) {
+	select {
+	case c.send <- data:
+	default:
+		c.conn.Close()
+	}
+}
+
+// sendTo queues a message for a single client if it is still connected.
+func sendTo(conn *websocket.Conn, msg WSMessage) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	wsClientsMu.RLock()
+	defer wsClientsMu.RUnlock()
+	if c, ok := wsClients[conn]; ok {
+		c.queue(data)
+	}
 }
 
 func InitWebSocket() {
@@ -39,16 +89,11 @@ func handleWSBroadcast() {
 		}
 
 		wsClientsMu.RLock()
-		for client := range wsClients {
-			err := client.WriteMessage(websocket.TextMessage, data)
-			if err != nil {
-				client.Close()
-				wsClientsMu.RUnlock()
-				wsClientsMu.Lock()
-				delete(wsClients, client)
-				wsClientsMu.Unlock()
-				wsClientsMu.RLock()
+		for conn, client := range wsClients {
+			if msg.to != nil && msg.to != conn {
+				continue
 			}
+			client.queue(data)
 		}
 		wsClientsMu.RUnlock()
 	}
@@ -63,6 +108,9 @@ func streamUpdates() {
 		torrents := GetAllTorrents()
 		wsBroadcast <- WSMessage{Type: "torrents", Data: torrents}
 
+		// Send peer details to clients looking at a torrent
+		sendWatchedPeers()
+
 		// Send aria2 updates if available
 		if IsAria2Available() {
 			downloads := GetAria2Downloads()
@@ -84,8 +132,10 @@ func WSHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	client := &wsClient{conn: conn, send: make(chan []byte, wsSendBuffer)}
+	go client.writeLoop()
 	wsClientsMu.Lock()
-	wsClients[conn] = true
+	wsClients[conn] = client
 	wsClientsMu.Unlock()
 
 	log.Printf("WebSocket client connected, total: %d", len(wsClients))
@@ -109,6 +159,8 @@ func WSHandler(w http.ResponseWriter, r *http.Request) {
 
 	wsClientsMu.Lock()
 	delete(wsClients, conn)
+	delete(wsWatching, conn)
+	close(client.send)
 	wsClientsMu.Unlock()
 	conn.Close()
 	log.Printf("WebSocket client disconnected, remaining: %d", len(wsClients))
@@ -116,8 +168,9 @@ func WSHandler(w http.ResponseWriter, r *http.Request) {
 
 func handleWSCommand(conn *websocket.Conn, msg []byte) {
 	var cmd struct {
-		Action string `json:"action"`
-		Data   string `json:"data"`
+		Action string          `json:"action"`
+		Data   string          `json:"data"`
+		Params json.RawMessage `json:"params,omitempty"`
 	}
 	if err := json.Unmarshal(msg, &cmd); err != nil {
 		return
@@ -128,16 +181,56 @@ func handleWSCommand(conn *websocket.Conn, msg []byte) {
 
 	switch cmd.Action {
 	case "add_torrent":
-		_, err = AddTorrentByMagnet(cmd.Data)
+		_, err = AddTorrent(cmd.Data, nil)
 		if err == nil {
 			response = map[string]string{"status": "ok", "message": "Torrent added"}
 		}
 	case "remove_torrent":
-		_, err = DeleteTorrentByID(cmd.Data)
+		var params struct {
+			DeleteData bool `json:"delete_data"`
+		}
+		json.Unmarshal(cmd.Params, &params)
+		var removed RemovedTorrent
+		if removed, err = RemoveTorrent(cmd.Data, params.DeleteData); err == nil {
+			response = removed
+		}
 	case "pause_torrent":
 		_, err = PauseTorrentByID(cmd.Data)
 	case "resume_torrent":
 		_, err = ResumeTorrentByID(cmd.Data)
+	case "set_limit":
+		var l SpeedLimit
+		if err = json.Unmarshal(cmd.Params, &l); err != nil {
+			break
+		}
+		if cmd.Data != "" {
+			err = fmt.Errorf("per-torrent speed limits are not supported")
+			break
+		}
+		err = SetGlobalSpeedLimit(l)
+	case "bulk":
+		var req BulkRequest
+		if err = json.Unmarshal(cmd.Params, &req); err != nil {
+			break
+		}
+		// Bulk actions can take long, they must not hold up the read loop
+		go func() {
+			results, err := ApplyBulk(req)
+			var response interface{} = map[string]interface{}{"status": "ok", "results": results}
+			if err != nil {
+				response = map[string]string{"status": "error", "message": err.Error()}
+			}
+			sendTo(conn, WSMessage{Type: "response", Data: response})
+		}()
+		return
+	case "watch_torrent":
+		wsClientsMu.Lock()
+		if cmd.Data == "" {
+			delete(wsWatching, conn)
+		} else {
+			wsWatching[conn] = cmd.Data
+		}
+		wsClientsMu.Unlock()
 	case "add_download":
 		if IsAria2Available() {
 			_, err = AddAria2Download(cmd.Data)
@@ -150,8 +243,29 @@ func handleWSCommand(conn *websocket.Conn, msg []byte) {
 		response = map[string]string{"status": "ok"}
 	}
 
-	data, _ := json.Marshal(WSMessage{Type: "response", Data: response})
-	conn.WriteMessage(websocket.TextMessage, data)
+	sendTo(conn, WSMessage{Type: "response", Data: response})
+}
+
+func sendWatchedPeers() {
+	wsClientsMu.RLock()
+	watching := make(map[*websocket.Conn]string, len(wsWatching))
+	for conn, id := range wsWatching {
+		watching[conn] = id
+	}
+	wsClientsMu.RUnlock()
+
+	peers := make(map[string]TorrentPeers)
+	for conn, id := range watching {
+		tp, ok := peers[id]
+		if !ok {
+			var err error
+			if tp, err = GetTorrentPeers(id); err != nil {
+				continue
+			}
+			peers[id] = tp
+		}
+		wsBroadcast <- WSMessage{Type: "peers", Data: tp, to: conn}
+	}
 }
 
 func BroadcastMessage(msgType string, data interface{}) {